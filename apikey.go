@@ -0,0 +1,209 @@
+package vira
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIKeyContextKey is the key the APIKey of an authenticated request is stored under in the Context.
+const APIKeyContextKey = "_vira/api-key"
+
+const defaultAPIKeyHeader = "X-API-Key"
+
+var (
+	// ErrAPIKeyMissing is returned when a request has no API key.
+	ErrAPIKeyMissing = errors.New("vira: missing API key")
+	// ErrAPIKeyInvalid is returned when an API key is unknown or does not match its hash.
+	ErrAPIKeyInvalid = errors.New("vira: invalid API key")
+)
+
+// APIKey describes an API key. Keys are made of their ID and a secret, "<id>.<secret>", so
+// that they can be looked up by ID and verified against their hash; see GenerateAPIKey.
+type APIKey struct {
+	// ID is the public part of the key. It is safe to log and to display.
+	ID string
+	// Hash is the SHA-256 hash of the whole key, see HashAPIKey.
+	Hash []byte
+	// Principal is the service or user the key was issued to.
+	Principal string
+	// Scopes are the scopes granted to the key.
+	Scopes []string
+}
+
+// APIKeyStore looks API keys up by ID.
+type APIKeyStore interface {
+	// APIKey returns the key with the given ID, or nil if there is none.
+	APIKey(id string) (*APIKey, error)
+}
+
+// APIKeys is a static set of keys.
+type APIKeys []APIKey
+
+var _ APIKeyStore = APIKeys(nil)
+
+// APIKey implements APIKeyStore.
+func (keys APIKeys) APIKey(id string) (*APIKey, error) {
+	for i := range keys {
+		if keys[i].ID == id {
+			return &keys[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// GenerateAPIKey returns a new random key, whose ID starts with prefix, such as "live_", and
+// its APIKey, to be completed with the principal and scopes and saved. Only the hash of the
+// key is kept: the key itself must be handed to the client, and cannot be recovered.
+func GenerateAPIKey(prefix string) (key string, apiKey APIKey, err error) {
+	var b [6 + 32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", APIKey{}, err
+	}
+	id := prefix + hex.EncodeToString(b[:6])
+	key = id + "." + base64.RawURLEncoding.EncodeToString(b[6:])
+	return key, APIKey{ID: id, Hash: HashAPIKey(key)}, nil
+}
+
+// HashAPIKey returns the SHA-256 hash of key. API keys are random enough for a fast hash.
+func HashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// APIKeyID returns the ID of key, i.e. what precedes the last ".".
+func APIKeyID(key string) (string, bool) {
+	i := strings.LastIndexByte(key, '.')
+	if i <= 0 || i == len(key)-1 {
+		return "", false
+	}
+	return key[:i], true
+}
+
+// APIKey returns the key authenticated by the APIKeyAuth middleware, or nil.
+func (c *Context) APIKey() *APIKey {
+	key, _ := c.Value(APIKeyContextKey).(*APIKey)
+	return key
+}
+
+// APIKeyAuthConfig defines the config for APIKeyAuth middleware.
+type APIKeyAuthConfig struct {
+	// Header is the request header the key is read from.
+	// Optional. Default value is "X-API-Key" when neither Query nor Cookie are set.
+	Header string
+
+	// Query is the query parameter the key is read from. Keys in URLs tend to end up in
+	// access logs, prefer headers when the clients allow it.
+	// Optional.
+	Query string
+
+	// Cookie is the name of the cookie the key is read from.
+	// Optional.
+	Cookie string
+
+	// Store looks the keys up by ID, and the keys are verified against their hash.
+	// Either Store or Lookup is required.
+	Store APIKeyStore
+
+	// Lookup returns the APIKey of key, or nil if key is not valid. Use it when the keys
+	// are not stored as described by APIKey; the Hash of the returned APIKey is ignored.
+	// Either Store or Lookup is required.
+	Lookup func(c *Context, key string) (*APIKey, error)
+
+	// LastUsed is called with the authenticated keys, to record when they were last used.
+	// It is called synchronously: it should not block on slow storage.
+	// Optional.
+	LastUsed func(key *APIKey, at time.Time)
+}
+
+// APIKeyAuth returns an API key authentication middleware, reading the keys from the
+// "X-API-Key" header and verifying them against store. See APIKeyAuthWithConfig for more details.
+func APIKeyAuth(store APIKeyStore) HandlerFunc {
+	return APIKeyAuthWithConfig(APIKeyAuthConfig{Store: store})
+}
+
+// APIKeyAuthWithConfig returns an APIKeyAuth middleware with config.
+// The key is read from the first configured source that has one: the header, the query
+// parameter, then the cookie. Once validated, the APIKey is stored in the Context, see
+// Context.APIKey, its principal is set to the AuthUserKey and its scopes to the AuthScopesKey,
+// so that RouterGroup.Authorize can require scopes. Requests without a valid key are aborted
+// with 401 (Unauthorized), and failures of the store with 500 (Internal Server Error).
+func APIKeyAuthWithConfig(conf APIKeyAuthConfig) HandlerFunc {
+	assert1((conf.Store == nil) != (conf.Lookup == nil), "either an API key store or a lookup func is required")
+	if conf.Header == "" && conf.Query == "" && conf.Cookie == "" {
+		conf.Header = defaultAPIKeyHeader
+	}
+
+	return func(c *Context) {
+		key := conf.extract(c)
+		if key == "" {
+			c.AbortWithError(http.StatusUnauthorized, ErrAPIKeyMissing) //nolint: errcheck
+			return
+		}
+
+		apiKey, err := conf.verify(c, key)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err) //nolint: errcheck
+			return
+		}
+		if apiKey == nil {
+			c.AbortWithError(http.StatusUnauthorized, ErrAPIKeyInvalid) //nolint: errcheck
+			return
+		}
+
+		c.Set(APIKeyContextKey, apiKey)
+		if apiKey.Principal != "" {
+			c.setAuthUser(apiKey.Principal)
+		}
+		if len(apiKey.Scopes) > 0 {
+			c.Set(AuthScopesKey, apiKey.Scopes)
+		}
+		if conf.LastUsed != nil {
+			conf.LastUsed(apiKey, time.Now())
+		}
+	}
+}
+
+func (conf *APIKeyAuthConfig) extract(c *Context) string {
+	if conf.Header != "" {
+		if key := c.requestHeader(conf.Header); key != "" {
+			return key
+		}
+	}
+	if conf.Query != "" {
+		if key := c.Query(conf.Query); key != "" {
+			return key
+		}
+	}
+	if conf.Cookie != "" {
+		if key, err := c.Cookie(conf.Cookie); err == nil {
+			return key
+		}
+	}
+	return ""
+}
+
+// verify returns the APIKey of key, or nil if key is not valid.
+func (conf *APIKeyAuthConfig) verify(c *Context, key string) (*APIKey, error) {
+	if conf.Lookup != nil {
+		return conf.Lookup(c, key)
+	}
+	id, ok := APIKeyID(key)
+	if !ok {
+		return nil, nil
+	}
+	apiKey, err := conf.Store.APIKey(id)
+	if err != nil || apiKey == nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(HashAPIKey(key), apiKey.Hash) != 1 {
+		return nil, nil
+	}
+	return apiKey, nil
+}
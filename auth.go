@@ -3,6 +3,7 @@ package vira
 import (
 	"crypto/subtle"
 	"encoding/base64"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -15,6 +16,12 @@ const AuthUserKey = "user"
 // AuthProxyUserKey is the cookie name for proxy_user credential in basic auth for proxy.
 const AuthProxyUserKey = "proxy_user"
 
+// setAuthUser sets the authenticated user to the AuthUserKey, and adds it to the logger of the request.
+func (c *Context) setAuthUser(user string) {
+	c.Set(AuthUserKey, user)
+	c.AddLogAttrs(slog.String("user_id", user))
+}
+
 // Accounts defines a key/value for user/pass list of authorized logins.
 type Accounts map[string]string
 
@@ -59,7 +66,7 @@ func BasicAuthForRealm(accounts Accounts, realm string) HandlerFunc {
 
 		// The user credentials was found, set user's id to key AuthUserKey in this context, the user's id can be read later using
 		// c.MustGet(vira.AuthUserKey).
-		c.Set(AuthUserKey, user)
+		c.setAuthUser(user)
 	}
 }
 
@@ -69,6 +76,39 @@ func BasicAuth(accounts Accounts) HandlerFunc {
 	return BasicAuthForRealm(accounts, "")
 }
 
+// Authenticator checks the credentials of a user. Implementations should compare secrets in
+// constant time, and are given the password in clear text to check it against a hash.
+type Authenticator func(user, password string) bool
+
+// BasicAuthFunc returns a Basic HTTP Authorization middleware checking the credentials with
+// authenticator, for example the Authenticate method of an Htpasswd or a database lookup.
+func BasicAuthFunc(authenticator Authenticator) HandlerFunc {
+	return BasicAuthFuncForRealm(authenticator, "")
+}
+
+// BasicAuthFuncForRealm works like BasicAuthFunc with the given realm.
+// If the realm is empty, "Authorization Required" will be used by default.
+func BasicAuthFuncForRealm(authenticator Authenticator, realm string) HandlerFunc {
+	assert1(authenticator != nil, "an authenticator is required")
+	if realm == "" {
+		realm = "Authorization Required"
+	}
+	realm = "Basic realm=" + strconv.Quote(realm) + ", charset=\"UTF-8\""
+	return func(c *Context) {
+		user, password, ok := c.Request.BasicAuth()
+		if !ok || user == "" || !authenticator(user, password) {
+			// Credentials doesn't match, we return 401 and abort handlers chain.
+			c.Header("WWW-Authenticate", realm)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		// The user credentials was found, set user's id to key AuthUserKey in this context, the user's id can be read later using
+		// c.MustGet(vira.AuthUserKey).
+		c.setAuthUser(user)
+	}
+}
+
 func processAccounts(accounts Accounts) authPairs {
 	length := len(accounts)
 	assert1(length > 0, "Empty list of authorized credentials")
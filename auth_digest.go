@@ -0,0 +1,334 @@
+package vira
+
+import (
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec // MD5 is required by the Digest scheme for legacy clients.
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Digest algorithms.
+const (
+	DigestSHA256 = "SHA-256"
+	DigestMD5    = "MD5"
+)
+
+const (
+	defaultDigestNonceTTL = 5 * time.Minute
+	digestNonceSize       = 8 + 8 + 16
+)
+
+// DigestHA1Func returns HA1, the hex encoded hash of "user:realm:password" with the given
+// algorithm (see DigestHA1), for user. It returns false if the user does not exist.
+type DigestHA1Func func(user, realm, algorithm string) (ha1 string, ok bool)
+
+// DigestAuthConfig defines the config for DigestAuth middleware.
+type DigestAuthConfig struct {
+	// Realm is the protection space of the credentials.
+	// Optional. Default value is "Authorization Required".
+	Realm string
+
+	// HA1 looks the credentials of the users up.
+	// Required.
+	HA1 DigestHA1Func
+
+	// Algorithms are the accepted algorithms, in order of preference.
+	// Optional. Default value is SHA-256 and MD5.
+	Algorithms []string
+
+	// NonceTTL is how long a nonce stays valid. Clients using an expired nonce are challenged
+	// again with stale=true, and retry without prompting the user.
+	// Optional. Default value is 5 minutes.
+	NonceTTL time.Duration
+
+	// Secret authenticates the nonces. Set it when several instances serve the same clients.
+	// Optional. By default, a random secret is generated.
+	Secret []byte
+
+	// UserHash resolves hashed usernames, i.e. the hash of "user:realm", into usernames.
+	// When set, clients are allowed to send hashed usernames (userhash=true).
+	// Optional.
+	UserHash func(hashed, realm, algorithm string) (user string, ok bool)
+}
+
+// DigestHA1 returns the HA1 value of the credentials for the given algorithm.
+func DigestHA1(algorithm, user, realm, password string) string {
+	return digestHash(algorithm, user+":"+realm+":"+password)
+}
+
+func digestHash(algorithm, data string) string {
+	var h hash.Hash
+	if algorithm == DigestMD5 {
+		h = md5.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DigestAuth returns a Digest HTTP Authorization middleware (RFC 7616) for realm, looking the
+// credentials up with ha1. See DigestAuthWithConfig for more details.
+func DigestAuth(realm string, ha1 DigestHA1Func) HandlerFunc {
+	return DigestAuthWithConfig(DigestAuthConfig{Realm: realm, HA1: ha1})
+}
+
+// DigestAuthWithConfig returns a DigestAuth middleware with config.
+// Only the "auth" quality of protection is supported. Each nonce can be used for several
+// requests, with increasing nonce counts, until it expires; replayed requests are rejected.
+// The user's id is set to the AuthUserKey.
+func DigestAuthWithConfig(conf DigestAuthConfig) HandlerFunc {
+	assert1(conf.HA1 != nil, "a HA1 lookup func is required")
+	if conf.Realm == "" {
+		conf.Realm = "Authorization Required"
+	}
+	if len(conf.Algorithms) == 0 {
+		conf.Algorithms = []string{DigestSHA256, DigestMD5}
+	}
+	for _, alg := range conf.Algorithms {
+		assert1(alg == DigestSHA256 || alg == DigestMD5, "unsupported digest algorithm: "+alg)
+	}
+	if conf.NonceTTL <= 0 {
+		conf.NonceTTL = defaultDigestNonceTTL
+	}
+	if len(conf.Secret) == 0 {
+		conf.Secret = make([]byte, 32)
+		_, err := rand.Read(conf.Secret)
+		assert1(err == nil, "cannot generate the digest secret")
+	}
+	d := &digestAuth{
+		conf:   conf,
+		opaque: hex.EncodeToString(hmacSum(conf.Secret, []byte("opaque"))[:16]),
+		counts: make(map[string]uint64),
+	}
+
+	return func(c *Context) {
+		user, stale := d.authenticate(c)
+		if user == "" {
+			if err := d.challenge(c, stale); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err) //nolint: errcheck
+				return
+			}
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.setAuthUser(user)
+	}
+}
+
+type digestAuth struct {
+	conf   DigestAuthConfig
+	opaque string
+
+	mu        sync.Mutex
+	counts    map[string]uint64 // last nonce count per nonce
+	lastSweep time.Time
+}
+
+// challenge adds a WWW-Authenticate header per algorithm, with a fresh nonce.
+func (d *digestAuth) challenge(c *Context, stale bool) error {
+	nonce, err := d.newNonce(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, alg := range d.conf.Algorithms {
+		var sb strings.Builder
+		sb.WriteString("Digest realm=")
+		sb.WriteString(strconv.Quote(d.conf.Realm))
+		sb.WriteString(`, qop="auth", algorithm=`)
+		sb.WriteString(alg)
+		sb.WriteString(", nonce=")
+		sb.WriteString(strconv.Quote(nonce))
+		sb.WriteString(", opaque=")
+		sb.WriteString(strconv.Quote(d.opaque))
+		sb.WriteString(`, charset=UTF-8`)
+		if d.conf.UserHash != nil {
+			sb.WriteString(", userhash=true")
+		}
+		if stale {
+			sb.WriteString(", stale=true")
+		}
+		c.Writer.Header().Add("WWW-Authenticate", sb.String())
+	}
+	return nil
+}
+
+// newNonce returns a nonce made of its creation time, random bytes and a MAC.
+func (d *digestAuth) newNonce(now time.Time) (string, error) {
+	var b [digestNonceSize]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(now.UnixNano()))
+	if _, err := rand.Read(b[8:16]); err != nil {
+		return "", err
+	}
+	copy(b[16:], hmacSum(d.conf.Secret, b[:16]))
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
+
+// checkNonce verifies the MAC of nonce and returns whether it has expired.
+func (d *digestAuth) checkNonce(nonce string, now time.Time) (valid, expired bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != digestNonceSize || !hmac.Equal(b[16:], hmacSum(d.conf.Secret, b[:16])[:16]) {
+		return false, false
+	}
+	created := time.Unix(0, int64(binary.BigEndian.Uint64(b[:8])))
+	return true, now.Sub(created) > d.conf.NonceTTL
+}
+
+// useNonceCount records the nonce count of a request, and returns false if it was already used.
+func (d *digestAuth) useNonceCount(nonce string, nc uint64, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.lastSweep) > d.conf.NonceTTL {
+		for n := range d.counts {
+			if _, expired := d.checkNonce(n, now); expired {
+				delete(d.counts, n)
+			}
+		}
+		d.lastSweep = now
+	}
+	if nc <= d.counts[nonce] {
+		return false
+	}
+	d.counts[nonce] = nc
+	return true
+}
+
+// authenticate returns the user of a valid Authorization header, or whether the nonce is stale.
+func (d *digestAuth) authenticate(c *Context) (user string, stale bool) {
+	scheme, params, ok := strings.Cut(c.requestHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Digest") {
+		return "", false
+	}
+	p := parseAuthParams(params)
+
+	alg := p["algorithm"]
+	if alg == "" {
+		alg = DigestMD5
+	}
+	if !d.acceptsAlgorithm(alg) || p["realm"] != d.conf.Realm || p["qop"] != "auth" ||
+		p["opaque"] != d.opaque || p["uri"] != c.Request.RequestURI || p["cnonce"] == "" {
+		return "", false
+	}
+	nc, err := strconv.ParseUint(p["nc"], 16, 64)
+	if err != nil || len(p["nc"]) != 8 {
+		return "", false
+	}
+
+	now := time.Now()
+	nonce := p["nonce"]
+	valid, expired := d.checkNonce(nonce, now)
+	if !valid {
+		return "", false
+	}
+
+	user, ok = d.username(p, alg)
+	ha1, found := "", false
+	if ok {
+		ha1, found = d.conf.HA1(user, d.conf.Realm, alg)
+	}
+	if !found {
+		// Compute a response anyway, so that unknown users take as long as known ones.
+		ha1 = digestHash(alg, nonce)
+	}
+	ha2 := digestHash(alg, c.Request.Method+":"+p["uri"])
+	expected := digestHash(alg, ha1+":"+nonce+":"+p["nc"]+":"+p["cnonce"]+":auth:"+ha2)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(p["response"]))) != 1 || !found {
+		return "", false
+	}
+	if expired {
+		// The credentials are right, the client can retry with a new nonce.
+		return "", true
+	}
+	if !d.useNonceCount(nonce, nc, now) {
+		return "", false
+	}
+	return user, false
+}
+
+func (d *digestAuth) acceptsAlgorithm(alg string) bool {
+	for _, a := range d.conf.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// username returns the user of the request, from a plain, extended or hashed username.
+func (d *digestAuth) username(p map[string]string, alg string) (string, bool) {
+	user := p["username"]
+	if ext, ok := p["username*"]; ok {
+		// RFC 5987 extended notation, such as UTF-8''J%C3%A4s%C3%B8n
+		charset, value, ok := strings.Cut(ext, "''")
+		if !ok || !strings.EqualFold(charset, "UTF-8") {
+			return "", false
+		}
+		decoded, err := url.PathUnescape(value)
+		if err != nil {
+			return "", false
+		}
+		user = decoded
+	}
+	if user == "" {
+		return "", false
+	}
+	if p["userhash"] == "true" {
+		if d.conf.UserHash == nil {
+			return "", false
+		}
+		return d.conf.UserHash(strings.ToLower(user), d.conf.Realm, alg)
+	}
+	return user, true
+}
+
+// parseAuthParams parses a comma separated list of auth-params, whose values are tokens or
+// quoted strings. Parameter names are lowercased.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return params
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var sb strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++ // closing quote
+			}
+			value, s = sb.String(), s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		params[name] = value
+	}
+}
@@ -0,0 +1,169 @@
+package vira
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AuthRolesKey is the key the roles of the authenticated user are stored under in the Context, as a []string.
+const AuthRolesKey = "_vira/roles"
+
+// AuthScopesKey is the key the scopes granted to the request are stored under in the Context, as a []string.
+const AuthScopesKey = "_vira/scopes"
+
+// ErrAccessDenied is returned when a request is denied by a Policy.
+var ErrAccessDenied = errors.New("vira: access denied")
+
+// Policy is an authorization rule, see RouterGroup.Authorize.
+type Policy struct {
+	// Name identifies the policy in RouteInfo.Policies.
+	Name string
+	// Allow returns true if the request is authorized.
+	Allow func(c *Context) bool
+
+	// scopes are the required scopes, reported to bearer token clients when they are missing.
+	scopes []string
+}
+
+// Public is a Policy allowing all requests. It opts routes out of DenyByDefault.
+var Public = Policy{Name: "public", Allow: func(*Context) bool { return true }}
+
+// denyAll guards the routes registered without policy in a DenyByDefault group.
+var denyAll = Policy{Name: "deny", Allow: func(*Context) bool { return false }}
+
+// RequirePolicy returns a Policy named name, allowing the requests for which allow returns true.
+func RequirePolicy(name string, allow func(c *Context) bool) Policy {
+	assert1(name != "" && allow != nil, "a policy needs a name and a predicate")
+	return Policy{Name: name, Allow: allow}
+}
+
+// RequireRoles returns a Policy allowing the requests whose user has at least one of the roles.
+func RequireRoles(roles ...string) Policy {
+	assert1(len(roles) > 0, "at least one role is required")
+	return Policy{
+		Name: "roles:" + strings.Join(roles, "|"),
+		Allow: func(c *Context) bool {
+			for _, role := range roles {
+				if c.HasRole(role) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// RequireScopes returns a Policy allowing the requests which were granted all the scopes.
+func RequireScopes(scopes ...string) Policy {
+	assert1(len(scopes) > 0, "at least one scope is required")
+	return Policy{
+		Name: "scopes:" + strings.Join(scopes, " "),
+		Allow: func(c *Context) bool {
+			return c.HasScopes(scopes...)
+		},
+		scopes: scopes,
+	}
+}
+
+// Roles returns the roles of the authenticated user, see AuthRolesKey.
+func (c *Context) Roles() []string {
+	return c.GetStringSlice(AuthRolesKey)
+}
+
+// HasRole returns true if the authenticated user has the given role.
+func (c *Context) HasRole(role string) bool {
+	for _, r := range c.Roles() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Scopes returns the scopes granted to the request, see AuthScopesKey.
+func (c *Context) Scopes() []string {
+	return c.GetStringSlice(AuthScopesKey)
+}
+
+// HasScopes returns true if all the given scopes were granted to the request.
+func (c *Context) HasScopes(scopes ...string) bool {
+	granted := c.Scopes()
+next:
+	for _, scope := range scopes {
+		for _, g := range granted {
+			if g == scope {
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Authorize returns a middleware allowing the requests authorized by all the policies, and
+// aborting the others with 403 (Forbidden). Requests with a bearer token missing required
+// scopes also get an RFC 6750 insufficient_scope challenge.
+// Prefer RouterGroup.Authorize, which also records the policies for Vira.Routes.
+func Authorize(policies ...Policy) HandlerFunc {
+	assert1(len(policies) > 0, "at least one policy is required")
+	return func(c *Context) {
+		for _, p := range policies {
+			if p.Allow(c) {
+				continue
+			}
+			_ = c.Error(fmt.Errorf("%w by policy %s", ErrAccessDenied, p.Name))
+			if len(p.scopes) > 0 {
+				if _, ok := bearerToken(c.requestHeader("Authorization")); ok {
+					c.Header("WWW-Authenticate", bearerChallenge("", "insufficient_scope", "",
+						"scope", strings.Join(p.scopes, " ")))
+				}
+			}
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+	}
+}
+
+// Authorize returns a new router group, with the same path, whose routes are only served
+// to the requests authorized by all the policies, in addition to the ones of group.
+// The policies are checked right before the handlers of each route, after all the middlewares
+// of the group, including the ones added later with Use, such as the authentication. Use it
+// on a single route to guard it alone. The policies guarding each route are listed in
+// RouteInfo.Policies.
+//
+//	admin := router.Group("/admin", vira.JWTAuth(keys)).Authorize(vira.RequireRoles("admin"))
+//	admin.GET("/users", listUsers)
+//	admin.Authorize(vira.RequireScopes("users:write")).POST("/users", createUser)
+func (group *RouterGroup) Authorize(policies ...Policy) *RouterGroup {
+	assert1(len(policies) > 0, "at least one policy is required")
+	child := group.Group("")
+	child.policies = append(child.policies, policies...)
+	return child
+}
+
+// DenyByDefault makes the routes registered afterwards on the group and its subgroups
+// deny all requests, unless they are guarded by at least one Policy. Use Public to opt a
+// route out explicitly.
+func (group *RouterGroup) DenyByDefault() IRoutes {
+	group.denyByDefault = true
+	return group.returnObj()
+}
+
+// routePolicies returns the names of the policies guarding the routes of the group, and the
+// handlers to prepend to the route handlers.
+func (group *RouterGroup) routePolicies() ([]string, HandlersChain) {
+	policies := group.policies
+	if len(policies) == 0 {
+		if !group.denyByDefault {
+			return nil, nil
+		}
+		policies = []Policy{denyAll}
+	}
+	names := make([]string, len(policies))
+	for i, p := range policies {
+		names[i] = p.Name
+	}
+	return names, HandlersChain{Authorize(policies...)}
+}
@@ -0,0 +1,383 @@
+package vira
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultCompressMinSize = 1024
+
+// Encoder compresses a response body. Reset makes it write to w, so that it can be reused.
+type Encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// NewEncoderFunc returns an Encoder writing to w with the compression level, 0 meaning
+// the default level of the encoding.
+type NewEncoderFunc func(w io.Writer, level int) (Encoder, error)
+
+var (
+	encodings     = map[string]NewEncoderFunc{}
+	encodingNames []string
+)
+
+func init() {
+	RegisterEncoding("gzip", func(w io.Writer, level int) (Encoder, error) {
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	})
+	// The "deflate" coding is the zlib format (RFC 9110, section 8.4.1.2).
+	RegisterEncoding("deflate", func(w io.Writer, level int) (Encoder, error) {
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		return zlib.NewWriterLevel(w, level)
+	})
+}
+
+// RegisterEncoding registers a content-coding, such as "br" or "zstd", for the Compress
+// middleware. It replaces the encoding if it is already registered.
+// RegisterEncoding is not safe for concurrent use: call it from an init function.
+func RegisterEncoding(name string, newEncoder NewEncoderFunc) {
+	assert1(name != "" && newEncoder != nil, "an encoding needs a name and a constructor")
+	name = strings.ToLower(name)
+	if _, ok := encodings[name]; !ok {
+		encodingNames = append(encodingNames, name)
+	}
+	encodings[name] = newEncoder
+}
+
+// DefaultCompressContentTypes are the media types compressed by default. A type ending with
+// "/*" matches all the subtypes.
+var DefaultCompressContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/problem+json",
+	"application/ld+json",
+	"application/manifest+json",
+	"application/javascript",
+	"application/xml",
+	"application/problem+xml",
+	"application/xhtml+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// CompressConfig defines the config for Compress middleware.
+type CompressConfig struct {
+	// Encodings are the content-codings offered to the clients, in order of preference.
+	// They must have been registered with RegisterEncoding.
+	// Optional. Default value is all the registered encodings: gzip, deflate, then the
+	// encodings registered by the application.
+	Encodings []string
+
+	// Level is the compression level, passed to the encoders.
+	// Optional. Default value is the default level of each encoding.
+	Level int
+
+	// MinSize is the size, in bytes, under which responses are not compressed.
+	// Optional. Default value is 1024.
+	MinSize int
+
+	// ContentTypes are the media types of the responses to compress.
+	// Optional. Default value is DefaultCompressContentTypes.
+	ContentTypes []string
+
+	// Skip is a Skipper that indicates which requests should not be handled.
+	// Optional.
+	Skip Skipper
+}
+
+// Compress returns a middleware compressing the responses with gzip or deflate, as
+// negotiated with the Accept-Encoding request header. See CompressWithConfig for more details.
+func Compress() HandlerFunc {
+	return CompressWithConfig(CompressConfig{})
+}
+
+// CompressWithConfig returns a Compress middleware with config.
+// Responses are compressed when they are large enough and their Content-Type is allowed.
+// Responses that are already encoded, partial (206 or with a Content-Range) or marked
+// Cache-Control: no-transform are sent unchanged, as well as the responses to Range requests.
+// Flushed responses, such as server-sent events, are compressed as they are flushed.
+// The strong entity-tags of compressed responses, for example set by ETag, are made weak.
+func CompressWithConfig(conf CompressConfig) HandlerFunc {
+	if len(conf.Encodings) == 0 {
+		conf.Encodings = encodingNames
+	}
+	if conf.MinSize <= 0 {
+		conf.MinSize = defaultCompressMinSize
+	}
+	if len(conf.ContentTypes) == 0 {
+		conf.ContentTypes = DefaultCompressContentTypes
+	}
+
+	pools := make(map[string]*sync.Pool, len(conf.Encodings))
+	offered := make([]string, len(conf.Encodings))
+	for i, name := range conf.Encodings {
+		name = strings.ToLower(name)
+		newEncoder, ok := encodings[name]
+		assert1(ok, "unknown encoding: "+name)
+		enc, err := newEncoder(io.Discard, conf.Level)
+		assert1(err == nil, "cannot create a "+name+" encoder")
+
+		pool := &sync.Pool{New: func() any {
+			enc, _ := newEncoder(io.Discard, conf.Level)
+			return enc
+		}}
+		pool.Put(enc)
+		pools[name], offered[i] = pool, name
+	}
+
+	return func(c *Context) {
+		if conf.Skip != nil && conf.Skip(c) {
+			c.Next()
+			return
+		}
+		addVary(c.Writer.Header(), "Accept-Encoding")
+		req := c.Request
+		if req.Method == http.MethodHead || req.Header.Get("Range") != "" || req.Header.Get("Upgrade") != "" {
+			c.Next()
+			return
+		}
+		encoding := negotiateEncoding(req.Header.Values("Accept-Encoding"), offered)
+		if encoding == "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, conf: &conf, encoding: encoding, pool: pools[encoding]}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+
+		if err := w.finish(); err != nil {
+			_ = c.Error(err)
+		}
+	}
+}
+
+// negotiateEncoding returns the offered encoding with the highest quality in the
+// Accept-Encoding header values, or "" if identity should be used. Ties are resolved
+// by the order of offered.
+func negotiateEncoding(accept []string, offered []string) string {
+	best, bestQ := "", 0.0
+	wildcard := -1.0
+	qualities := make(map[string]float64, len(offered))
+	for _, line := range accept {
+		for _, part := range strings.Split(line, ",") {
+			name, params, _ := strings.Cut(part, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			q := 1.0
+			if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+			if name == "*" {
+				wildcard = q
+			} else {
+				qualities[name] = q
+			}
+		}
+	}
+	for _, name := range offered {
+		q, ok := qualities[name]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the beginning of the response body, until it is large enough to
+// decide whether to compress it, then streams it through an Encoder or unchanged.
+type compressWriter struct {
+	ResponseWriter
+	conf     *CompressConfig
+	encoding string
+	pool     *sync.Pool
+
+	buf         []byte
+	written     bool
+	decided     bool
+	compressing bool
+	enc         Encoder
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.written = true
+		if len(w.buf) == 0 && w.belowMinSize() {
+			w.decide(false)
+		} else {
+			w.buf = append(w.buf, data...)
+			if len(w.buf) < w.conf.MinSize {
+				return len(data), nil
+			}
+			w.decide(true)
+			return len(data), w.flushBuffer()
+		}
+	}
+	if w.compressing {
+		return w.enc.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if w.decided {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.written = true
+}
+
+func (w *compressWriter) Written() bool {
+	return w.written || w.ResponseWriter.Written()
+}
+
+func (w *compressWriter) Size() int {
+	if w.decided {
+		return w.ResponseWriter.Size()
+	}
+	if !w.written {
+		return noWritten
+	}
+	return len(w.buf)
+}
+
+// Flush compresses and sends the data written so far, whatever its size.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+		if err := w.flushBuffer(); err != nil {
+			debugPrint("cannot flush compressed response: %v", err)
+			return
+		}
+	}
+	if w.compressing {
+		if err := w.enc.Flush(); err != nil {
+			debugPrint("cannot flush compressed response: %v", err)
+			return
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// belowMinSize returns true if the response declares a Content-Length under the minimum size.
+func (w *compressWriter) belowMinSize() bool {
+	n, err := strconv.Atoi(w.Header().Get("Content-Length"))
+	return err == nil && n < w.conf.MinSize
+}
+
+// decide sets the response headers and starts the response, compressed if compress is
+// true and the response is eligible. Both representations vary with Accept-Encoding, and
+// the entity-tag of the compressed one is weakened, as it is not byte-for-byte identical to
+// the uncompressed one anymore.
+func (w *compressWriter) decide(compress bool) {
+	w.decided = true
+	header := w.Header()
+	addVary(header, "Accept-Encoding")
+	if compress && header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if compress && w.compressible(header) {
+		w.compressing = true
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.enc = w.pool.Get().(Encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *compressWriter) compressible(header http.Header) bool {
+	status := w.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-transform") {
+			return false
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, allowed := range w.conf.ContentTypes {
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, allowed[:len(allowed)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushBuffer writes the buffered data, once the decision is made.
+func (w *compressWriter) flushBuffer() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.compressing {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// finish writes the rest of the response and releases the encoder.
+func (w *compressWriter) finish() error {
+	if !w.decided {
+		if !w.written {
+			return nil
+		}
+		// The whole body is smaller than the minimum size.
+		w.decide(false)
+		if err := w.flushBuffer(); err != nil {
+			return err
+		}
+	}
+	if !w.compressing {
+		return nil
+	}
+	err := w.enc.Close()
+	w.enc.Reset(io.Discard)
+	w.pool.Put(w.enc)
+	w.enc = nil
+	return err
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"io"
 	"log"
+	"log/slog"
 	"math"
+	"mime"
 	"mime/multipart"
 	"net"
 	"net/http"
@@ -83,6 +85,11 @@ type Context struct {
 	// SameSite allows a server to define a cookie attribute making it impossible for
 	// the browser to send this cookie along with cross-site requests.
 	sameSite http.SameSite
+
+	// logger is the request-scoped logger, built by Context.Logger on first use.
+	logger *slog.Logger
+	// logAttrs are the attributes added by Context.AddLogAttrs.
+	logAttrs []any
 }
 
 /************************************/
@@ -102,6 +109,8 @@ func (c *Context) reset() {
 	c.queryCache = nil
 	c.formCache = nil
 	c.sameSite = 0
+	c.logger = nil
+	c.logAttrs = nil
 	*c.params = (*c.params)[:0]
 	*c.skippedNodes = (*c.skippedNodes)[:0]
 }
@@ -120,6 +129,8 @@ func (c *Context) Copy() *Context {
 	cp.index = abortIndex
 	cp.handlers = nil
 	cp.fullPath = c.fullPath
+	cp.logger = c.logger
+	cp.logAttrs = c.logAttrs[:len(c.logAttrs):len(c.logAttrs)]
 
 	cKeys := c.Keys
 	cp.Keys = make(map[string]any, len(cKeys))
@@ -598,6 +609,66 @@ func (c *Context) BindWith(obj any, b binding.Binding) error {
 	return b.Bind(c.Request, obj)
 }
 
+// MustBind is like Bind, but aborts the request on error, see MustBindWith.
+func (c *Context) MustBind(obj any) error {
+	b := binding.Default(c.Request.Method, c.ContentType())
+	return c.MustBindWith(obj, b)
+}
+
+// MustBindJSON is a shortcut for c.MustBindWith(obj, binding.JSON).
+func (c *Context) MustBindJSON(obj any) error {
+	return c.MustBindWith(obj, binding.JSON)
+}
+
+// MustBindXML is a shortcut for c.MustBindWith(obj, binding.XML).
+func (c *Context) MustBindXML(obj any) error {
+	return c.MustBindWith(obj, binding.XML)
+}
+
+// MustBindQuery is a shortcut for c.MustBindWith(obj, binding.Query).
+func (c *Context) MustBindQuery(obj any) error {
+	return c.MustBindWith(obj, binding.Query)
+}
+
+// MustBindYAML is a shortcut for c.MustBindWith(obj, binding.YAML).
+func (c *Context) MustBindYAML(obj any) error {
+	return c.MustBindWith(obj, binding.YAML)
+}
+
+// MustBindHeader is a shortcut for c.MustBindWith(obj, binding.Header).
+func (c *Context) MustBindHeader(obj any) error {
+	return c.MustBindWith(obj, binding.Header)
+}
+
+// MustBindUri is like BindUri, but aborts the request on error, see MustBindWith.
+func (c *Context) MustBindUri(obj any) error {
+	if err := c.BindUri(obj); err != nil {
+		c.abortWithBindError(err)
+		return err
+	}
+	return nil
+}
+
+// MustBindWith binds the passed struct pointer using the specified binding engine.
+// On error, it aborts the request with 400 (Bad Request) and pushes the error to c.Errors, with
+// the ErrorTypeBind type. The response is a Problem Details when Vira.ProblemDetails is enabled.
+func (c *Context) MustBindWith(obj any, b binding.Binding) error {
+	if err := c.BindWith(obj, b); err != nil {
+		c.abortWithBindError(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Context) abortWithBindError(err error) {
+	if c.engine.ProblemDetails {
+		c.AbortWithProblem(http.StatusBadRequest, render.Problem{Detail: err.Error()})
+	} else {
+		c.AbortWithStatus(http.StatusBadRequest)
+	}
+	c.Error(err).SetType(ErrorTypeBind) //nolint: errcheck
+}
+
 // ShouldBindBodyWith is similar with ShouldBindWith, but it stores the request
 // body into the context, and reuse when it is called again.
 //
@@ -775,6 +846,38 @@ func (c *Context) SetCookie(name, value string, maxAge int, path, domain string,
 	})
 }
 
+// SetCookieStruct adds a Set-Cookie header to the ResponseWriter's headers, giving full
+// control over the cookie attributes (Expires, SameSite...). The value is sent as is.
+// If the cookie has no Path, "/" is used, and if it has no SameSite mode, the one set
+// with SetSameSite is used. The cookie is not modified.
+// The provided cookie must have a valid Name. Invalid cookies may be silently dropped.
+func (c *Context) SetCookieStruct(cookie *http.Cookie) {
+	http.SetCookie(c.Writer, c.cookieWithDefaults(cookie))
+}
+
+// SetPartitionedCookie works like SetCookieStruct, but adds the Partitioned attribute
+// (CHIPS), which stores the cookie in a jar partitioned by the top-level site. Partitioned
+// cookies must be Secure, so the Secure attribute is always set.
+func (c *Context) SetPartitionedCookie(cookie *http.Cookie) {
+	ck := c.cookieWithDefaults(cookie)
+	ck.Secure = true
+	if v := ck.String(); v != "" {
+		c.Writer.Header().Add("Set-Cookie", v+"; Partitioned")
+	}
+}
+
+// cookieWithDefaults returns a copy of cookie with the default Path and SameSite mode.
+func (c *Context) cookieWithDefaults(cookie *http.Cookie) *http.Cookie {
+	ck := *cookie
+	if ck.Path == "" {
+		ck.Path = "/"
+	}
+	if ck.SameSite == http.SameSiteDefaultMode {
+		ck.SameSite = c.sameSite
+	}
+	return &ck
+}
+
 // Cookie returns the named cookie provided in the request or
 // ErrNoCookie if not found. And return the named cookie is unescaped.
 // If multiple cookies match the given name, only one cookie will
@@ -876,12 +979,51 @@ func (c *Context) Redirect(code int, location string) {
 }
 
 // DataFromReader writes the specified reader into the body stream and updates the HTTP code.
+// If code is 200 and the reader implements io.ReadSeeker or io.ReaderAt, Range requests are
+// honored; other status codes are sent as they are.
 func (c *Context) DataFromReader(code int, contentLength int64, contentType string, reader io.Reader, extraHeaders map[string]string) {
-	c.Render(code, render.Reader{
+	r := render.Reader{
 		Headers:       extraHeaders,
 		ContentType:   contentType,
 		ContentLength: contentLength,
 		Reader:        reader,
+	}
+	if code == http.StatusOK {
+		r.Request = c.Request
+	}
+	c.Render(code, r)
+}
+
+// ServeContent replies to the request using the content in the provided ReadSeeker,
+// like http.ServeContent. It evaluates the request preconditions (see CheckPreconditions),
+// handles Range and If-Range requests and sends the Last-Modified and ETag headers
+// when modtime and etag are provided.
+// The Content-Type is derived from the extension of name, or sniffed from the content.
+// The etag must be a quoted entity-tag, for example `"v1"` or `W/"v1"`.
+func (c *Context) ServeContent(name string, modtime time.Time, etag string, content io.ReadSeeker) {
+	if !c.CheckPreconditions(etag, modtime) {
+		return
+	}
+	contentType := c.Writer.Header().Get("Content-Type")
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if contentType == "" {
+		var buf [512]byte
+		n, _ := io.ReadFull(content, buf[:])
+		contentType = http.DetectContentType(buf[:n])
+		if _, err := content.Seek(int64(-n), io.SeekCurrent); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err) //nolint: errcheck
+			return
+		}
+	}
+	c.Render(http.StatusOK, render.Reader{
+		ContentType:   contentType,
+		ContentLength: -1,
+		Reader:        content,
+		Request:       c.Request,
+		ModTime:       modtime,
+		ETag:          etag,
 	})
 }
 
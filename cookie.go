@@ -0,0 +1,221 @@
+package vira
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	bytesconv "github.com/vira-software/vira/internal"
+)
+
+// minKeyLength is the minimum length in bytes of a secret added to a Keyring.
+const minKeyLength = 32
+
+// ErrCookieTampered is returned when a signed or encrypted cookie cannot be verified
+// with any of the keys of the engine Keyring, i.e. it was forged, altered or
+// produced with a key that has since been retired.
+var ErrCookieTampered = errors.New("vira: cookie value is invalid or has been tampered with")
+
+// Keyring holds the secrets used to sign and encrypt cookie values.
+// The first key is the primary one and is used to produce new values, while all
+// keys are tried when verifying. This allows keys to be rotated without invalidating
+// the cookies already issued: add the new key with Rotate, and drop the old one with
+// SetKeys once the cookies it produced have expired.
+// A Keyring is safe for concurrent use.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []derivedKey
+}
+
+type derivedKey struct {
+	aead cipher.AEAD
+	hmac []byte
+}
+
+// NewKeyring returns a Keyring with the given secrets, the first one being the primary key.
+// Each secret must be at least 32 bytes long.
+func NewKeyring(keys ...[]byte) *Keyring {
+	k := &Keyring{}
+	k.SetKeys(keys...)
+	return k
+}
+
+// SetKeys replaces all the secrets of the keyring, the first one being the primary key.
+func (k *Keyring) SetKeys(keys ...[]byte) {
+	assert1(len(keys) > 0, "a keyring needs at least one key")
+	derived := make([]derivedKey, 0, len(keys))
+	for _, key := range keys {
+		derived = append(derived, deriveKey(key))
+	}
+	k.mu.Lock()
+	k.keys = derived
+	k.mu.Unlock()
+}
+
+// Rotate makes key the primary key. The previous keys are kept to verify existing values.
+func (k *Keyring) Rotate(key []byte) {
+	dk := deriveKey(key)
+	k.mu.Lock()
+	k.keys = append([]derivedKey{dk}, k.keys...)
+	k.mu.Unlock()
+}
+
+func (k *Keyring) snapshot() []derivedKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys
+}
+
+// deriveKey derives independent signing and encryption keys from secret,
+// so that the same secret is never used by two algorithms.
+func deriveKey(secret []byte) derivedKey {
+	assert1(len(secret) >= minKeyLength, "keyring keys must be at least 32 bytes long")
+	block, err := aes.NewCipher(hmacSum(secret, []byte("vira cookie encryption")))
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return derivedKey{
+		aead: aead,
+		hmac: hmacSum(secret, []byte("vira cookie signing")),
+	}
+}
+
+func hmacSum(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// Sign returns value with an HMAC-SHA256 signature bound to the cookie name,
+// encoded so that it can be used as a cookie value.
+func (k *Keyring) Sign(name, value string) string {
+	payload := base64.RawURLEncoding.EncodeToString(bytesconv.StringToBytes(value))
+	mac := hmacSum(k.snapshot()[0].hmac, bytesconv.StringToBytes(name), []byte{'|'}, bytesconv.StringToBytes(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac)
+}
+
+// Verify checks a value produced by Sign for the cookie name and returns the original value.
+// It returns ErrCookieTampered if the signature does not match any key of the keyring.
+func (k *Keyring) Verify(name, signed string) (string, error) {
+	payload, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return "", ErrCookieTampered
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	for _, key := range k.snapshot() {
+		expected := hmacSum(key.hmac, bytesconv.StringToBytes(name), []byte{'|'}, bytesconv.StringToBytes(payload))
+		if hmac.Equal(mac, expected) {
+			value, err := base64.RawURLEncoding.DecodeString(payload)
+			if err != nil {
+				return "", ErrCookieTampered
+			}
+			return string(value), nil
+		}
+	}
+	return "", ErrCookieTampered
+}
+
+// Encrypt encrypts and authenticates value with AES-256-GCM, binding it to the cookie name.
+// The result is encoded so that it can be used as a cookie value.
+func (k *Keyring) Encrypt(name, value string) (string, error) {
+	aead := k.snapshot()[0].aead
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, bytesconv.StringToBytes(value), bytesconv.StringToBytes(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt for the cookie name.
+// It returns ErrCookieTampered if the value cannot be authenticated with any key of the keyring.
+func (k *Keyring) Decrypt(name, encrypted string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	for _, key := range k.snapshot() {
+		aead := key.aead
+		if len(sealed) < aead.NonceSize() {
+			return "", ErrCookieTampered
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if value, err := aead.Open(nil, nonce, ciphertext, bytesconv.StringToBytes(name)); err == nil {
+			return string(value), nil
+		}
+	}
+	return "", ErrCookieTampered
+}
+
+func (c *Context) keyring() *Keyring {
+	assert1(c.engine != nil && c.engine.Keyring != nil, "no keyring configured, set Vira.Keyring to use signed or encrypted cookies")
+	return c.engine.Keyring
+}
+
+// SetSignedCookie works like SetCookie, but signs the value with the primary key of
+// the engine Keyring so that it cannot be altered by the client. The value is not encrypted.
+func (c *Context) SetSignedCookie(name, value string, maxAge int, path, domain string, secure, httpOnly bool) {
+	c.setCookie(name, c.keyring().Sign(name, value), maxAge, path, domain, secure, httpOnly)
+}
+
+// SignedCookie returns the value of the named cookie set with SetSignedCookie.
+// It returns http.ErrNoCookie if the cookie is not found and ErrCookieTampered if its
+// signature cannot be verified with any key of the engine Keyring.
+func (c *Context) SignedCookie(name string) (string, error) {
+	cookie, err := c.Request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return c.keyring().Verify(name, cookie.Value)
+}
+
+// SetEncryptedCookie works like SetCookie, but encrypts and authenticates the value
+// with the primary key of the engine Keyring, so that it can be neither read nor altered by the client.
+func (c *Context) SetEncryptedCookie(name, value string, maxAge int, path, domain string, secure, httpOnly bool) {
+	encrypted, err := c.keyring().Encrypt(name, value)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.setCookie(name, encrypted, maxAge, path, domain, secure, httpOnly)
+}
+
+// EncryptedCookie returns the decrypted value of the named cookie set with SetEncryptedCookie.
+// It returns http.ErrNoCookie if the cookie is not found and ErrCookieTampered if it cannot
+// be authenticated with any key of the engine Keyring.
+func (c *Context) EncryptedCookie(name string) (string, error) {
+	cookie, err := c.Request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return c.keyring().Decrypt(name, cookie.Value)
+}
+
+// setCookie adds a Set-Cookie header with a value which is already safe to use in a cookie.
+func (c *Context) setCookie(name, value string, maxAge int, path, domain string, secure, httpOnly bool) {
+	c.SetCookieStruct(&http.Cookie{
+		Name:     name,
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     path,
+		Domain:   domain,
+		Secure:   secure,
+		HttpOnly: httpOnly,
+	})
+}
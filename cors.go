@@ -0,0 +1,214 @@
+package vira
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultCORSMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost,
+	http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// CORSConfig defines the config for CORS middleware.
+type CORSConfig struct {
+	// AllowOrigins is a list of origins a cross-origin request can be executed from.
+	// An origin may contain a single "*" wildcard to match subdomains, for example
+	// "https://*.example.com", and "*" allows all origins.
+	// Optional. Default value is []string{"*"} unless AllowOriginFunc is set.
+	AllowOrigins []string
+
+	// AllowOriginFunc is a predicate validating the origin of a request. It is used
+	// when the origin does not match AllowOrigins.
+	// Optional.
+	AllowOriginFunc func(c *Context, origin string) bool
+
+	// AllowMethods is a list of methods the client is allowed to use with cross-origin requests.
+	// Optional. Default value is GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowMethods []string
+
+	// AllowHeaders is a list of non simple headers the client is allowed to use with cross-origin requests.
+	// Optional. By default, the headers requested by the preflight request are allowed.
+	AllowHeaders []string
+
+	// ExposeHeaders indicates which headers are safe to expose to the API of a CORS API specification.
+	// Optional.
+	ExposeHeaders []string
+
+	// AllowCredentials indicates whether the request can include user credentials like
+	// cookies, HTTP authentication or client side SSL certificates. It requires explicit
+	// AllowOrigins or an AllowOriginFunc: it cannot be combined with the "*" origin.
+	// Optional. Default value is false.
+	AllowCredentials bool
+
+	// MaxAge indicates how long the results of a preflight request can be cached.
+	// Optional. By default, no Access-Control-Max-Age header is sent.
+	MaxAge time.Duration
+
+	// AllowPrivateNetwork allows requests from public websites to servers in a private
+	// network, as per the Private Network Access specification.
+	// Optional. Default value is false.
+	AllowPrivateNetwork bool
+}
+
+// CORS returns a Cross-Origin Resource Sharing middleware allowing all origins.
+// See CORSWithConfig for more details.
+func CORS() HandlerFunc {
+	return CORSWithConfig(CORSConfig{})
+}
+
+// CORSWithConfig returns a CORS middleware with config.
+// Preflight requests are answered with 204 (No Content) and abort the handlers chain.
+// Register the middleware with Vira.Use, so that it is also part of the NoRoute and NoMethod
+// chains: preflight requests are then answered even when no OPTIONS route exists for the path.
+func CORSWithConfig(conf CORSConfig) HandlerFunc {
+	cors := newCORS(conf)
+	return func(c *Context) {
+		origin := c.requestHeader("Origin")
+		header := c.Writer.Header()
+		if !cors.allowAll || cors.credentials {
+			addVary(header, "Origin")
+		}
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		preflight := c.Request.Method == http.MethodOptions && c.requestHeader("Access-Control-Request-Method") != ""
+		if preflight {
+			addVary(header, "Access-Control-Request-Method", "Access-Control-Request-Headers")
+			if cors.privateNetwork {
+				addVary(header, "Access-Control-Request-Private-Network")
+			}
+		}
+
+		if !cors.allowOrigin(c, origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if cors.allowAll && !cors.credentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if cors.credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if cors.exposeHeaders != "" {
+				header.Set("Access-Control-Expose-Headers", cors.exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", cors.allowMethods)
+		if cors.allowHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", cors.allowHeaders)
+		} else if requested := c.requestHeader("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+		if cors.maxAge != "" {
+			header.Set("Access-Control-Max-Age", cors.maxAge)
+		}
+		if cors.privateNetwork && c.requestHeader("Access-Control-Request-Private-Network") == "true" {
+			header.Set("Access-Control-Allow-Private-Network", "true")
+		}
+		// The preflight response may end up on the NoMethod path, which announces the
+		// methods of the route, so the Allow header is dropped to avoid confusion.
+		header.Del("Allow")
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+type cors struct {
+	allowAll       bool
+	origins        map[string]struct{}
+	wildcards      []wildcardOrigin
+	originFunc     func(c *Context, origin string) bool
+	allowMethods   string
+	allowHeaders   string
+	exposeHeaders  string
+	credentials    bool
+	maxAge         string
+	privateNetwork bool
+}
+
+// wildcardOrigin matches the origins starting with prefix and ending with suffix.
+type wildcardOrigin struct {
+	prefix, suffix string
+}
+
+func (w wildcardOrigin) match(origin string) bool {
+	if len(origin) <= len(w.prefix)+len(w.suffix) ||
+		!strings.HasPrefix(origin, w.prefix) || !strings.HasSuffix(origin, w.suffix) {
+		return false
+	}
+	// The wildcard only stands for subdomain labels.
+	return !strings.ContainsAny(origin[len(w.prefix):len(origin)-len(w.suffix)], "/:@?#")
+}
+
+func newCORS(conf CORSConfig) *cors {
+	cors := &cors{
+		origins:        make(map[string]struct{}),
+		originFunc:     conf.AllowOriginFunc,
+		credentials:    conf.AllowCredentials,
+		privateNetwork: conf.AllowPrivateNetwork,
+		exposeHeaders:  strings.Join(conf.ExposeHeaders, ", "),
+		allowHeaders:   strings.Join(conf.AllowHeaders, ", "),
+	}
+
+	origins := conf.AllowOrigins
+	if len(origins) == 0 && conf.AllowOriginFunc == nil {
+		origins = []string{"*"}
+	}
+	for _, origin := range origins {
+		origin = strings.ToLower(origin)
+		switch i := strings.IndexByte(origin, '*'); {
+		case origin == "*":
+			cors.allowAll = true
+		case i >= 0:
+			assert1(strings.Count(origin, "*") == 1, "CORS origins can contain a single wildcard: "+origin)
+			cors.wildcards = append(cors.wildcards, wildcardOrigin{prefix: origin[:i], suffix: origin[i+1:]})
+		default:
+			cors.origins[origin] = struct{}{}
+		}
+	}
+	assert1(!cors.allowAll || !cors.credentials,
+		"CORS cannot allow credentials from all origins, set explicit AllowOrigins or an AllowOriginFunc")
+
+	methods := conf.AllowMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	cors.allowMethods = strings.ToUpper(strings.Join(methods, ", "))
+
+	if conf.MaxAge > 0 {
+		cors.maxAge = strconv.FormatInt(int64(conf.MaxAge/time.Second), 10)
+	}
+	return cors
+}
+
+func (cors *cors) allowOrigin(c *Context, origin string) bool {
+	if cors.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if _, ok := cors.origins[lower]; ok {
+		return true
+	}
+	for _, w := range cors.wildcards {
+		if w.match(lower) {
+			return true
+		}
+	}
+	return cors.originFunc != nil && cors.originFunc(c, origin)
+}
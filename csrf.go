@@ -0,0 +1,330 @@
+package vira
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vira-software/vira/binding"
+)
+
+// CSRFKey is the key the CSRF token of the current request is stored under in the Context.
+const CSRFKey = "_vira/csrf"
+
+const (
+	csrfFieldKey      = "_vira/csrf-field"
+	csrfSessionKey    = "_csrf"
+	csrfSecretLength  = 32
+	defaultCSRFCookie = "vira_csrf"
+	defaultCSRFHeader = "X-CSRF-Token"
+	defaultCSRFField  = "csrf_token"
+)
+
+var (
+	// ErrCSRFTokenMissing is returned when an unsafe request carries no CSRF token.
+	ErrCSRFTokenMissing = errors.New("vira: CSRF token is missing")
+	// ErrCSRFTokenInvalid is returned when the CSRF token of an unsafe request does not match.
+	ErrCSRFTokenInvalid = errors.New("vira: CSRF token is invalid")
+	// ErrCSRFOriginMismatch is returned when an unsafe request comes from an untrusted origin.
+	ErrCSRFOriginMismatch = errors.New("vira: request origin is not trusted")
+)
+
+// CSRFStorage is where the CSRF middleware keeps the secret the tokens are checked against.
+type CSRFStorage int
+
+const (
+	// CSRFSignedCookie keeps the secret in an HttpOnly cookie signed with the engine Keyring.
+	CSRFSignedCookie CSRFStorage = iota
+	// CSRFSessionStorage keeps the secret in the Session. The Sessions middleware must run before.
+	CSRFSessionStorage
+	// CSRFDoubleSubmit keeps the secret in a cookie readable by scripts, which send it back in
+	// the CSRF header (double-submit cookie pattern). Prefer the other storages when possible.
+	CSRFDoubleSubmit
+)
+
+// CSRFConfig defines the config for CSRF middleware.
+type CSRFConfig struct {
+	// Storage is where the secret is kept.
+	// Optional. Default value is CSRFSignedCookie.
+	Storage CSRFStorage
+
+	// Keyring signs the cookie of CSRFSignedCookie.
+	// Optional. Default value is the engine Keyring.
+	Keyring *Keyring
+
+	// CookieName, Path and Domain of the CSRF cookie.
+	// Optional. Default value of CookieName is "vira_csrf", of Path is "/".
+	CookieName string
+	Path       string
+	Domain     string
+
+	// Insecure removes the Secure attribute from the CSRF cookie. Only use it
+	// for local development over plain HTTP.
+	// Optional. Default value is false.
+	Insecure bool
+
+	// HeaderName is the request header the token is read from.
+	// Optional. Default value is "X-CSRF-Token".
+	HeaderName string
+
+	// FormField is the form field the token is read from, when the header is missing.
+	// Optional. Default value is "csrf_token".
+	FormField string
+
+	// TrustedOrigins are the origins, besides the one of the request, unsafe requests may
+	// come from, for example "https://app.example.com".
+	// Optional.
+	TrustedOrigins []string
+
+	// ExemptRoutes are the routes, as returned by Context.FullPath, whose requests are not
+	// checked, for example webhook endpoints. The token is still available to their handlers.
+	// Optional.
+	ExemptRoutes []string
+
+	// ErrorHandler is called when a request is rejected, after the error has been added to the Context.
+	// Optional. By default, the request is aborted with 403 (Forbidden).
+	ErrorHandler HandlerFunc
+
+	// Skip is a Skipper that indicates which requests are neither checked nor given a token.
+	// Optional.
+	Skip Skipper
+}
+
+// CSRFToken returns the CSRF token of the current request, to be sent back in the CSRF
+// header or form field. The token changes on every request to mitigate BREACH attacks,
+// but all of them stay valid. It returns an empty string if the CSRF middleware is not in use.
+func (c *Context) CSRFToken() string {
+	return c.GetString(CSRFKey)
+}
+
+// CSRFField returns a hidden form input holding the CSRF token of the current request,
+// to be included in the forms of HTML templates.
+func (c *Context) CSRFField() template.HTML {
+	token := c.CSRFToken()
+	if token == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(c.GetString(csrfFieldKey)) +
+		`" value="` + token + `">`)
+}
+
+// CSRF returns a middleware protecting against cross-site request forgery, keeping its secret
+// in a signed cookie. See CSRFWithConfig for more details.
+func CSRF() HandlerFunc {
+	return CSRFWithConfig(CSRFConfig{})
+}
+
+// CSRFWithConfig returns a CSRF middleware with config.
+// Every request gets a token, available with Context.CSRFToken and Context.CSRFField.
+// Requests with unsafe methods (all but GET, HEAD, OPTIONS and TRACE) must come from the
+// origin of the request or a trusted one, as told by their Origin or Referer header, and
+// carry a token in the CSRF header or form field; they are rejected with 403 (Forbidden)
+// otherwise.
+func CSRFWithConfig(conf CSRFConfig) HandlerFunc {
+	if conf.CookieName == "" {
+		conf.CookieName = defaultCSRFCookie
+	}
+	if conf.Path == "" {
+		conf.Path = "/"
+	}
+	if conf.HeaderName == "" {
+		conf.HeaderName = defaultCSRFHeader
+	}
+	if conf.FormField == "" {
+		conf.FormField = defaultCSRFField
+	}
+	errorHandler := conf.ErrorHandler
+	if errorHandler == nil {
+		errorHandler = func(c *Context) {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+	}
+	trusted := make(map[string]struct{}, len(conf.TrustedOrigins))
+	for _, origin := range conf.TrustedOrigins {
+		trusted[strings.ToLower(strings.TrimSuffix(origin, "/"))] = struct{}{}
+	}
+	exempt := make(map[string]struct{}, len(conf.ExemptRoutes))
+	for _, route := range conf.ExemptRoutes {
+		exempt[route] = struct{}{}
+	}
+
+	return func(c *Context) {
+		if conf.Skip != nil && conf.Skip(c) {
+			c.Next()
+			return
+		}
+
+		secret, err := conf.loadSecret(c)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err) //nolint: errcheck
+			return
+		}
+		token, err := maskCSRFSecret(secret)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err) //nolint: errcheck
+			return
+		}
+		c.Set(CSRFKey, token)
+		c.Set(csrfFieldKey, conf.FormField)
+
+		if _, ok := exempt[c.FullPath()]; ok || !isUnsafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		if err := conf.verify(c, secret, trusted); err != nil {
+			_ = c.Error(err)
+			errorHandler(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// loadSecret returns the secret of the client, creating and storing a new one if needed.
+func (conf *CSRFConfig) loadSecret(c *Context) ([]byte, error) {
+	var encoded string
+	switch conf.Storage {
+	case CSRFSessionStorage:
+		if value, ok := c.Session().Get(csrfSessionKey); ok {
+			encoded, _ = value.(string)
+		}
+	case CSRFDoubleSubmit:
+		if cookie, err := c.Request.Cookie(conf.CookieName); err == nil {
+			encoded = cookie.Value
+		}
+	default:
+		if cookie, err := c.Request.Cookie(conf.CookieName); err == nil {
+			encoded, _ = conf.keyring(c).Verify(conf.CookieName, cookie.Value)
+		}
+	}
+	if secret, err := base64.RawURLEncoding.DecodeString(encoded); err == nil && len(secret) == csrfSecretLength {
+		return secret, nil
+	}
+
+	secret := make([]byte, csrfSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	encoded = base64.RawURLEncoding.EncodeToString(secret)
+	switch conf.Storage {
+	case CSRFSessionStorage:
+		c.Session().Set(csrfSessionKey, encoded)
+	case CSRFDoubleSubmit:
+		conf.setCookie(c, encoded, false)
+	default:
+		conf.setCookie(c, conf.keyring(c).Sign(conf.CookieName, encoded), true)
+	}
+	return secret, nil
+}
+
+func (conf *CSRFConfig) keyring(c *Context) *Keyring {
+	if conf.Keyring != nil {
+		return conf.Keyring
+	}
+	return c.keyring()
+}
+
+func (conf *CSRFConfig) setCookie(c *Context, value string, httpOnly bool) {
+	sameSite := c.sameSite
+	if sameSite == http.SameSiteDefaultMode {
+		sameSite = http.SameSiteLaxMode
+	}
+	c.SetCookieStruct(&http.Cookie{
+		Name:     conf.CookieName,
+		Value:    value,
+		Path:     conf.Path,
+		Domain:   conf.Domain,
+		Secure:   !conf.Insecure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	})
+}
+
+// verify checks the origin and the token of an unsafe request.
+func (conf *CSRFConfig) verify(c *Context, secret []byte, trusted map[string]struct{}) error {
+	if !conf.trustedOrigin(c, trusted) {
+		return ErrCSRFOriginMismatch
+	}
+
+	token := c.requestHeader(conf.HeaderName)
+	if token == "" {
+		if strings.HasPrefix(c.ContentType(), binding.MIMEMultipartPOSTForm) {
+			if err := c.Request.ParseMultipartForm(c.engine.MaxMultipartMemory); err != nil &&
+				!errors.Is(err, http.ErrNotMultipart) {
+				return ErrCSRFTokenMissing
+			}
+		}
+		token = c.Request.PostFormValue(conf.FormField)
+	}
+	if token == "" {
+		return ErrCSRFTokenMissing
+	}
+	if !checkCSRFToken(token, secret) {
+		return ErrCSRFTokenInvalid
+	}
+	return nil
+}
+
+// trustedOrigin checks the Origin header of the request or, when it is missing, its Referer
+// header. Requests with neither are trusted, except over TLS where the Referer is required.
+func (conf *CSRFConfig) trustedOrigin(c *Context, trusted map[string]struct{}) bool {
+	source := c.requestHeader("Origin")
+	if source == "" || source == "null" {
+		source = c.requestHeader("Referer")
+		if source == "" {
+			return c.Request.TLS == nil
+		}
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	if _, ok := trusted[origin]; ok {
+		return true
+	}
+	if c.Request.TLS != nil && u.Scheme != "https" {
+		return false
+	}
+	return strings.EqualFold(u.Host, c.Request.Host)
+}
+
+// maskCSRFSecret returns a token made of a random mask followed by the secret XORed with
+// the mask, so that the token differs on every response.
+func maskCSRFSecret(secret []byte) (string, error) {
+	token := make([]byte, 2*csrfSecretLength)
+	if _, err := rand.Read(token[:csrfSecretLength]); err != nil {
+		return "", err
+	}
+	for i, b := range secret {
+		token[csrfSecretLength+i] = b ^ token[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// checkCSRFToken reports whether token matches secret. The token is either masked, or the
+// raw secret as read from the cookie by scripts with CSRFDoubleSubmit.
+func checkCSRFToken(token string, secret []byte) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return false
+	}
+	switch len(decoded) {
+	case csrfSecretLength:
+	case 2 * csrfSecretLength:
+		for i := 0; i < csrfSecretLength; i++ {
+			decoded[csrfSecretLength+i] ^= decoded[i]
+		}
+		decoded = decoded[csrfSecretLength:]
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare(decoded, secret) == 1
+}
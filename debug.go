@@ -7,7 +7,7 @@ import (
 	"strings"
 )
 
-const ginSupportMinGoVer = 18
+const ginSupportMinGoVer = 21
 
 // IsDebugging returns true if the framework is running in debug mode.
 // Use SetMode(vira.ReleaseMode) to disable debug mode.
@@ -60,7 +60,7 @@ func getMinVer(v string) (uint64, error) {
 
 func debugPrintWARNINGDefault() {
 	if v, e := getMinVer(runtime.Version()); e == nil && v < ginSupportMinGoVer {
-		debugPrint(`[WARNING] Now Vira requires Go 1.18+.
+		debugPrint(`[WARNING] Now Vira requires Go 1.21+.
 
 `)
 	}
@@ -0,0 +1,151 @@
+package vira
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultDecompressMaxSize = 10 << 20 // 10 MB
+
+// ErrUnsupportedEncoding is returned when a request body has a Content-Encoding that cannot be decoded.
+var ErrUnsupportedEncoding = errors.New("vira: unsupported content encoding")
+
+// NewDecoderFunc returns a reader decoding r.
+type NewDecoderFunc func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	decodings     = map[string]NewDecoderFunc{}
+	decodingNames []string
+)
+
+func init() {
+	RegisterDecoding("gzip", func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	})
+	RegisterDecoding("deflate", zlib.NewReader)
+}
+
+// RegisterDecoding registers a content-coding, such as "br" or "zstd", for the Decompress
+// middleware. It replaces the decoding if it is already registered.
+// RegisterDecoding is not safe for concurrent use: call it from an init function.
+func RegisterDecoding(name string, newDecoder NewDecoderFunc) {
+	assert1(name != "" && newDecoder != nil, "a decoding needs a name and a constructor")
+	name = strings.ToLower(name)
+	if _, ok := decodings[name]; !ok {
+		decodingNames = append(decodingNames, name)
+	}
+	decodings[name] = newDecoder
+}
+
+// DecompressConfig defines the config for Decompress middleware.
+type DecompressConfig struct {
+	// MaxSize is the maximum size, in bytes, of a decompressed request body. Reading past it
+	// fails with an *http.MaxBytesError, so that small compressed bodies cannot expand into
+	// huge ones.
+	// Optional. Default value is 10 MB.
+	MaxSize int64
+
+	// Skip is a Skipper that indicates which requests should not be handled.
+	// Optional.
+	Skip Skipper
+}
+
+// Decompress returns a middleware decoding the request bodies compressed with gzip or
+// deflate. See DecompressWithConfig for more details.
+func Decompress() HandlerFunc {
+	return DecompressWithConfig(DecompressConfig{})
+}
+
+// DecompressWithConfig returns a Decompress middleware with config.
+// Request bodies are decoded as per their Content-Encoding header, which is removed along
+// with Content-Length, so that the handlers and bindings read the decoded body. Requests
+// with an encoding that is not registered are aborted with 415 (Unsupported Media Type) and
+// an Accept-Encoding header listing the supported ones (RFC 7694), and requests whose body
+// cannot be decoded with 400 (Bad Request).
+func DecompressWithConfig(conf DecompressConfig) HandlerFunc {
+	if conf.MaxSize <= 0 {
+		conf.MaxSize = defaultDecompressMaxSize
+	}
+
+	return func(c *Context) {
+		if conf.Skip != nil && conf.Skip(c) {
+			c.Next()
+			return
+		}
+		req := c.Request
+		codings := contentCodings(req.Header.Values("Content-Encoding"))
+		if len(codings) == 0 || req.Body == nil || req.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		for _, coding := range codings {
+			if _, ok := decodings[coding]; !ok {
+				c.Header("Accept-Encoding", strings.Join(decodingNames, ", "))
+				c.AbortWithError(http.StatusUnsupportedMediaType, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, coding)) //nolint: errcheck
+				return
+			}
+		}
+
+		// The codings are listed in the order they were applied.
+		body := &decodedBody{closers: []io.Closer{req.Body}}
+		var r io.Reader = req.Body
+		for i := len(codings) - 1; i >= 0; i-- {
+			decoder, err := decodings[codings[i]](r)
+			if err != nil {
+				body.Close()
+				c.AbortWithError(http.StatusBadRequest, err) //nolint: errcheck
+				return
+			}
+			body.closers = append(body.closers, decoder)
+			r = decoder
+		}
+		body.Reader = http.MaxBytesReader(c.Writer, io.NopCloser(r), conf.MaxSize)
+
+		req.Body = body
+		req.ContentLength = -1
+		req.Header.Del("Content-Encoding")
+		req.Header.Del("Content-Length")
+		defer body.Close()
+
+		c.Next()
+	}
+}
+
+// contentCodings returns the content-codings of a Content-Encoding header, except identity.
+func contentCodings(values []string) []string {
+	var codings []string
+	for _, line := range values {
+		for _, coding := range strings.Split(line, ",") {
+			if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	return codings
+}
+
+// decodedBody is a decoded request body, closing the decoders and the original body.
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+	closed  bool
+}
+
+func (b *decodedBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if cerr := b.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+package vira
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/vira-software/vira/binding"
+	"github.com/vira-software/vira/render"
+)
+
+// ErrorMapping describes the response to the errors matched by an ErrorRegistry.
+type ErrorMapping struct {
+	// Status is the status code of the response.
+	// Optional. Default value is 500 (Internal Server Error).
+	Status int
+
+	// Type and Title identify the problem type of Problem Details responses, see render.Problem.
+	// Optional. Default Title is the text of the status code.
+	Type  string
+	Title string
+
+	// Public makes the message of the errors visible to the clients. The errors of the
+	// ErrorTypePublic type are always public.
+	// Optional.
+	Public bool
+
+	// Body returns the body of the response, rendered as JSON or XML, instead of the default
+	// Problem Details or {"error": message} body.
+	// Optional.
+	Body func(c *Context, err *Error) any
+}
+
+type errorRule struct {
+	match   func(err *Error) bool
+	mapping ErrorMapping
+}
+
+// ErrorRegistry maps the errors of the Context to responses. The rules are tried in the order
+// they were registered, the first matching one wins. A registry must be filled before it is
+// used by the ErrorHandler middleware, and is not safe for concurrent registration.
+type ErrorRegistry struct {
+	rules []errorRule
+}
+
+// DefaultErrorRegistry is the registry used by ErrorHandler.
+var DefaultErrorRegistry = NewErrorRegistry()
+
+// NewErrorRegistry returns an empty ErrorRegistry.
+func NewErrorRegistry() *ErrorRegistry {
+	return &ErrorRegistry{}
+}
+
+// Is maps the errors matching target, according to errors.Is.
+//
+//	vira.DefaultErrorRegistry.Is(sql.ErrNoRows, vira.ErrorMapping{Status: http.StatusNotFound})
+func (r *ErrorRegistry) Is(target error, mapping ErrorMapping) *ErrorRegistry {
+	assert1(target != nil, "target error must not be nil")
+	return r.Match(func(err *Error) bool { return errors.Is(err.Err, target) }, mapping)
+}
+
+// As maps the errors matching the type target points to, according to errors.As. As
+// errors.As, it panics if target is not a non-nil pointer to a type implementing error, or
+// to an interface.
+//
+//	vira.DefaultErrorRegistry.As(new(*ValidationError), vira.ErrorMapping{Status: http.StatusUnprocessableEntity, Public: true})
+func (r *ErrorRegistry) As(target any, mapping ErrorMapping) *ErrorRegistry {
+	typ := reflect.TypeOf(target)
+	assert1(typ != nil && typ.Kind() == reflect.Pointer && !reflect.ValueOf(target).IsNil(),
+		"target must be a non-nil pointer")
+	elem := typ.Elem()
+	assert1(elem.Kind() == reflect.Interface || elem.Implements(reflect.TypeOf((*error)(nil)).Elem()),
+		"target must point to an interface or to a type implementing error")
+	return r.Match(func(err *Error) bool {
+		return errors.As(err.Err, reflect.New(elem).Interface())
+	}, mapping)
+}
+
+// Type maps the errors having one of the flags of typ, such as ErrorTypeBind.
+func (r *ErrorRegistry) Type(typ ErrorType, mapping ErrorMapping) *ErrorRegistry {
+	return r.Match(func(err *Error) bool { return err.IsType(typ) }, mapping)
+}
+
+// Match maps the errors for which match returns true.
+func (r *ErrorRegistry) Match(match func(err *Error) bool, mapping ErrorMapping) *ErrorRegistry {
+	if mapping.Status == 0 {
+		mapping.Status = http.StatusInternalServerError
+	}
+	r.rules = append(r.rules, errorRule{match: match, mapping: mapping})
+	return r
+}
+
+// Lookup returns the mapping of err. Without matching rule, the binding errors are public
+// and mapped to 400 (Bad Request), the other ones to 500 (Internal Server Error).
+func (r *ErrorRegistry) Lookup(err *Error) ErrorMapping {
+	for _, rule := range r.rules {
+		if rule.match(err) {
+			return rule.mapping
+		}
+	}
+	if err.IsType(ErrorTypeBind) {
+		return ErrorMapping{Status: http.StatusBadRequest, Public: true}
+	}
+	return ErrorMapping{Status: http.StatusInternalServerError}
+}
+
+// ErrorHandlerConfig defines the config for ErrorHandler middleware.
+type ErrorHandlerConfig struct {
+	// Registry maps the errors to responses.
+	// Optional. Default value is DefaultErrorRegistry.
+	Registry *ErrorRegistry
+
+	// Offered are the formats offered to the clients, in order of preference, among
+	// "application/problem+json", "application/json", "application/problem+xml" and
+	// "application/xml".
+	// Optional. Default value is all of them.
+	Offered []string
+}
+
+// ErrorHandler returns a middleware rendering the errors of the Context with
+// DefaultErrorRegistry. See ErrorHandlerWithConfig for more details.
+func ErrorHandler() HandlerFunc {
+	return ErrorHandlerWithConfig(ErrorHandlerConfig{})
+}
+
+// ErrorHandlerWithConfig returns an ErrorHandler middleware with config.
+// When the handlers return with errors, see Context.Error, the last one is mapped by the
+// registry to the status and the body of the response. The body is negotiated with the Accept
+// header: Problem Details, see Context.Problem, or an {"error": message} object, as JSON or XML.
+// Only the message of public errors is rendered; private errors are replaced by the Title of
+// the mapping or the text of the status code, and logged with Context.Logger along with the
+// other private errors.
+// The handlers should call Context.Error and return without writing the response: if the status
+// was already written, for example by Context.AbortWithError, it is kept, and if the body was
+// written, the errors are only logged.
+func ErrorHandlerWithConfig(conf ErrorHandlerConfig) HandlerFunc {
+	if conf.Registry == nil {
+		conf.Registry = DefaultErrorRegistry
+	}
+	if len(conf.Offered) == 0 {
+		conf.Offered = problemOffers[:4]
+	}
+
+	return func(c *Context) {
+		c.Next()
+		last := c.Errors.Last()
+		if last == nil {
+			return
+		}
+
+		mapping := conf.Registry.Lookup(last)
+		public := mapping.Public || last.IsType(ErrorTypePublic)
+		status := mapping.Status
+		if c.Writer.Written() {
+			status = c.Writer.Status()
+		}
+		for _, err := range c.Errors {
+			if err.IsType(ErrorTypePublic) || (err == last && public) {
+				continue
+			}
+			level := slog.LevelWarn
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			c.Logger().Log(c, level, "request error", "error", err.Err, "status", status)
+		}
+		if c.Writer.Size() > 0 || c.IsWebsocket() {
+			return
+		}
+
+		message := mapping.Title
+		if message == "" {
+			message = http.StatusText(status)
+		}
+		if public {
+			message = last.Error()
+		}
+		format := c.NegotiateFormat(conf.Offered...)
+		xml := strings.Contains(format, "xml")
+		switch {
+		case mapping.Body != nil:
+			if xml {
+				c.XML(status, mapping.Body(c, last))
+			} else {
+				c.JSON(status, mapping.Body(c, last))
+			}
+		case strings.HasPrefix(format, "application/problem+"):
+			problem := render.Problem{Type: mapping.Type, Title: mapping.Title}
+			if public {
+				problem.Detail = message
+			}
+			c.Problem(status, problem)
+		case format == binding.MIMEXML:
+			c.XML(status, H{"error": message})
+		default:
+			c.JSON(status, H{"error": message})
+		}
+	}
+}
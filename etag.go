@@ -0,0 +1,323 @@
+package vira
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultETagMaxBodySize = 1 << 20 // 1 MB
+
+// ETagConfig defines the config for ETag middleware.
+type ETagConfig struct {
+	// Weak makes the middleware generate weak entity-tags (W/"...") instead of strong ones.
+	// Optional. Default value is false.
+	Weak bool
+
+	// MaxBodySize is the largest response body, in bytes, buffered to compute an entity-tag.
+	// Larger or flushed responses are streamed to the client unchanged.
+	// Optional. Default value is 1 MB.
+	MaxBodySize int
+
+	// Validators returns the current entity-tag and modification time of the requested
+	// resource. It is used to evaluate If-Match and If-Unmodified-Since preconditions
+	// of unsafe methods (POST, PUT, PATCH, DELETE...), answering 412 when they fail.
+	// Optional. When nil, handlers of unsafe methods should call Context.CheckPreconditions.
+	Validators func(c *Context) (etag string, lastModified time.Time)
+
+	// Skip is a Skipper that indicates which responses should not be handled.
+	// Optional.
+	Skip Skipper
+}
+
+// ETag returns a middleware that buffers GET and HEAD responses with status 200,
+// computes a strong entity-tag for them and answers conditional requests
+// (If-None-Match, If-Modified-Since, If-Match, If-Unmodified-Since) with 304 or 412.
+func ETag() HandlerFunc {
+	return ETagWithConfig(ETagConfig{})
+}
+
+// ETagWithConfig returns an ETag middleware with config.
+// See ETag() for more details.
+func ETagWithConfig(conf ETagConfig) HandlerFunc {
+	maxBodySize := conf.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultETagMaxBodySize
+	}
+
+	return func(c *Context) {
+		if conf.Skip != nil && conf.Skip(c) {
+			c.Next()
+			return
+		}
+
+		method := c.Request.Method
+		if method != http.MethodGet && method != http.MethodHead {
+			if conf.Validators != nil && isUnsafeMethod(method) && hasWritePreconditions(c.Request) {
+				etag, lastModified := conf.Validators(c)
+				if code := evalPreconditions(c.Request, etag, lastModified); code != 0 {
+					c.AbortWithStatus(code)
+					return
+				}
+			}
+			c.Next()
+			return
+		}
+
+		w := &etagWriter{ResponseWriter: c.Writer, limit: maxBodySize}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+
+		w.finish(c, conf.Weak)
+	}
+}
+
+// CheckPreconditions evaluates the conditional request headers (If-Match, If-Unmodified-Since,
+// If-None-Match and If-Modified-Since) against the current entity-tag and modification time of
+// the resource, as per RFC 9110 section 13.2.2. Either validator may be left empty.
+// The validators are sent in the ETag and Last-Modified response headers.
+//
+// It returns false when the request has been answered with 304 (Not Modified) or
+// 412 (Precondition Failed), in which case the handler should return:
+//
+//	router.PUT("/doc/:id", func(c *vira.Context) {
+//	    doc := load(c.Param("id"))
+//	    if !c.CheckPreconditions(doc.ETag, doc.UpdatedAt) {
+//	        return
+//	    }
+//	    // ...
+//	})
+func (c *Context) CheckPreconditions(etag string, lastModified time.Time) bool {
+	header := c.Writer.Header()
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	if !isZeroTime(lastModified) {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	switch code := evalPreconditions(c.Request, etag, lastModified); code {
+	case http.StatusNotModified:
+		writeNotModified(header)
+		c.AbortWithStatus(code)
+		return false
+	case http.StatusPreconditionFailed:
+		c.AbortWithStatus(code)
+		return false
+	}
+	return true
+}
+
+// evalPreconditions returns 304 or 412 if one of the preconditions of req fails, 0 otherwise.
+func evalPreconditions(req *http.Request, etag string, lastModified time.Time) int {
+	if im := req.Header.Get("If-Match"); im != "" {
+		if !matchETag(im, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := req.Header.Get("If-Unmodified-Since"); ius != "" && !isZeroTime(lastModified) {
+		if t, err := http.ParseTime(ius); err == nil && lastModified.Truncate(time.Second).After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	method := req.Method
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if matchETag(inm, etag, true) {
+			if method == http.MethodGet || method == http.MethodHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := req.Header.Get("If-Modified-Since"); ims != "" && !isZeroTime(lastModified) &&
+		(method == http.MethodGet || method == http.MethodHead) {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// matchETag reports whether etag is matched by the comma separated list of entity-tags
+// in header, using the weak comparison function if weak is true.
+func matchETag(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = textproto.TrimString(candidate)
+		if candidate == "*" {
+			return etag != ""
+		}
+		if etag == "" || candidate == "" {
+			continue
+		}
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func hasWritePreconditions(req *http.Request) bool {
+	return req.Header.Get("If-Match") != "" || req.Header.Get("If-Unmodified-Since") != "" ||
+		req.Header.Get("If-None-Match") != ""
+}
+
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
+
+// writeNotModified removes the representation headers which must not be sent with a 304 response.
+func writeNotModified(header http.Header) {
+	delete(header, "Content-Type")
+	delete(header, "Content-Length")
+	delete(header, "Content-Encoding")
+	if header.Get("ETag") != "" {
+		delete(header, "Last-Modified")
+	}
+}
+
+// computeETag returns the entity-tag of body.
+func computeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// etagWriter buffers the response body until the handlers chain returns, so that
+// an entity-tag can be computed. It falls back to streaming once the body grows
+// past limit or the response is flushed.
+type etagWriter struct {
+	ResponseWriter
+	buf       bytes.Buffer
+	limit     int
+	written   bool
+	streaming bool
+}
+
+func (w *etagWriter) Write(data []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(data)
+	}
+	w.written = true
+	if w.buf.Len()+len(data) > w.limit {
+		if err := w.stream(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buf.Write(data)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.WriteString(s)
+	}
+	return w.Write([]byte(s))
+}
+
+func (w *etagWriter) WriteHeaderNow() {
+	if w.streaming {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.written = true
+}
+
+func (w *etagWriter) Written() bool {
+	return w.written || w.ResponseWriter.Written()
+}
+
+func (w *etagWriter) Size() int {
+	if w.streaming {
+		return w.ResponseWriter.Size()
+	}
+	if !w.written {
+		return noWritten
+	}
+	return w.buf.Len()
+}
+
+func (w *etagWriter) Flush() {
+	if err := w.stream(); err != nil {
+		debugPrint("cannot flush buffered response: %v", err)
+		return
+	}
+	w.ResponseWriter.Flush()
+}
+
+// stream writes the buffered body and switches the writer to pass-through mode.
+func (w *etagWriter) stream() error {
+	if w.streaming {
+		return nil
+	}
+	w.streaming = true
+	w.ResponseWriter.WriteHeaderNow()
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// finish computes the entity-tag of the buffered response, evaluates the request
+// preconditions and writes the response.
+func (w *etagWriter) finish(c *Context, weak bool) {
+	if w.streaming || !w.written {
+		return
+	}
+	header := w.Header()
+	if w.Status() == http.StatusOK {
+		etag := header.Get("ETag")
+		if etag == "" {
+			etag = computeETag(w.buf.Bytes(), weak)
+			header.Set("ETag", etag)
+		}
+		var lastModified time.Time
+		if lm := header.Get("Last-Modified"); lm != "" {
+			lastModified, _ = http.ParseTime(lm)
+		}
+		if code := evalPreconditions(c.Request, etag, lastModified); code != 0 {
+			if code == http.StatusNotModified {
+				writeNotModified(header)
+			} else {
+				delete(header, "Content-Length")
+			}
+			w.ResponseWriter.WriteHeader(code)
+			w.ResponseWriter.WriteHeaderNow()
+			return
+		}
+		if header.Get("Content-Length") == "" && header.Get("Content-Encoding") == "" {
+			header.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+		}
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	if _, err := io.Copy(w.ResponseWriter, &w.buf); err != nil {
+		_ = c.Error(err)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/vira-software/vira
 
-go 1.20
+go 1.21
 
 require (
 	github.com/go-playground/validator/v10 v10.18.0
@@ -14,7 +14,7 @@ require (
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
+	golang.org/x/crypto v0.21.0
 	golang.org/x/sync v0.6.0
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
@@ -0,0 +1,316 @@
+package vira
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"hash"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswdCheckInterval is how often the htpasswd file is checked for changes.
+const htpasswdCheckInterval = time.Second
+
+// Htpasswd authenticates users against an htpasswd file, made of "user:hash" lines. The
+// supported hashes are bcrypt ($2a$, $2b$, $2y$) and SHA-crypt ($5$ and $6$); lines with
+// other hashes are ignored with a warning. The file is reloaded when it changes; if it
+// cannot be read anymore, the previous users are kept.
+// An Htpasswd is safe for concurrent use.
+type Htpasswd struct {
+	path string
+
+	mu      sync.RWMutex
+	users   map[string]string
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// NewHtpasswd loads the htpasswd file at path.
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.load(info); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Authenticate implements Authenticator. Unknown users are checked against a dummy bcrypt
+// hash, so that they cannot easily be told apart from existing ones by timing.
+func (h *Htpasswd) Authenticate(user, password string) bool {
+	h.reloadIfChanged()
+	h.mu.RLock()
+	hashed, ok := h.users[user]
+	h.mu.RUnlock()
+	if !ok {
+		// Spend as much time as for an existing user.
+		_ = bcrypt.CompareHashAndPassword(dummyBcryptHash(), []byte(password))
+		return false
+	}
+	return checkPasswordHash(hashed, password)
+}
+
+func (h *Htpasswd) reloadIfChanged() {
+	now := time.Now()
+	h.mu.RLock()
+	due := now.Sub(h.checked) >= htpasswdCheckInterval
+	h.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	h.mu.Lock()
+	if now.Sub(h.checked) < htpasswdCheckInterval {
+		h.mu.Unlock()
+		return
+	}
+	h.checked = now
+	modTime, size := h.modTime, h.size
+	h.mu.Unlock()
+
+	info, err := os.Stat(h.path)
+	if err != nil {
+		debugPrint("[WARNING] Cannot reload htpasswd file %s: %v", h.path, err)
+		return
+	}
+	if info.ModTime().Equal(modTime) && info.Size() == size {
+		return
+	}
+	if err := h.load(info); err != nil {
+		debugPrint("[WARNING] Cannot reload htpasswd file %s: %v", h.path, err)
+	}
+}
+
+func (h *Htpasswd) load(info os.FileInfo) error {
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		return err
+	}
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		user, hashed, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("vira: %s:%d: malformed htpasswd line", h.path, line)
+		}
+		if !isSupportedPasswordHash(hashed) {
+			debugPrint("[WARNING] %s:%d: unsupported password hash for user %q, ignoring it", h.path, line, user)
+			continue
+		}
+		users[user] = hashed
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.users, h.modTime, h.size = users, info.ModTime(), info.Size()
+	h.mu.Unlock()
+	return nil
+}
+
+func isSupportedPasswordHash(hashed string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$5$", "$6$"} {
+		if strings.HasPrefix(hashed, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPasswordHash reports whether password matches the bcrypt or SHA-crypt hash, in constant time.
+func checkPasswordHash(hashed, password string) bool {
+	switch {
+	case strings.HasPrefix(hashed, "$5$"):
+		return checkSHACrypt(sha256.New, "$5$", sha256CryptOrder, hashed, password)
+	case strings.HasPrefix(hashed, "$6$"):
+		return checkSHACrypt(sha512.New, "$6$", sha512CryptOrder, hashed, password)
+	default:
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil
+	}
+}
+
+var (
+	dummyBcryptOnce sync.Once
+	dummyBcrypt     []byte
+)
+
+// dummyBcryptHash returns a hash compared against when a user does not exist.
+func dummyBcryptHash() []byte {
+	dummyBcryptOnce.Do(func() {
+		dummyBcrypt, _ = bcrypt.GenerateFromPassword([]byte("vira dummy password"), bcrypt.DefaultCost)
+	})
+	return dummyBcrypt
+}
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSalt       = 16
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// sha256CryptOrder and sha512CryptOrder are the byte permutations used to encode the digests.
+var (
+	sha256CryptOrder = []int{
+		0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14,
+		15, 25, 5, 6, 16, 26, 27, 7, 17, 18, 28, 8, 9, 19, 29,
+		31, 30,
+	}
+	sha512CryptOrder = []int{
+		0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4,
+		47, 5, 26, 6, 27, 48, 28, 49, 7, 50, 8, 29, 9, 30, 51,
+		31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35,
+		15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+		62, 20, 41, 63,
+	}
+)
+
+// checkSHACrypt verifies password against a SHA-crypt hash, as specified by Ulrich Drepper.
+func checkSHACrypt(newHash func() hash.Hash, magic string, order []int, hashed, password string) bool {
+	params := strings.TrimPrefix(hashed, magic)
+	rounds, customRounds := shaCryptDefaultRounds, false
+	if strings.HasPrefix(params, "rounds=") {
+		value, rest, ok := strings.Cut(strings.TrimPrefix(params, "rounds="), "$")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil {
+			return false
+		}
+		if n < shaCryptMinRounds {
+			n = shaCryptMinRounds
+		} else if n > shaCryptMaxRounds {
+			n = shaCryptMaxRounds
+		}
+		rounds, customRounds, params = n, true, rest
+	}
+	salt, _, ok := strings.Cut(params, "$")
+	if !ok {
+		return false
+	}
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+
+	computed := shaCrypt(newHash, []byte(password), []byte(salt), rounds, order)
+	prefix := magic
+	if customRounds {
+		prefix += "rounds=" + strconv.Itoa(rounds) + "$"
+	}
+	expected := prefix + salt + "$" + computed
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(hashed)) == 1
+}
+
+func shaCrypt(newHash func() hash.Hash, password, salt []byte, rounds int, order []int) string {
+	h := newHash()
+	size := h.Size()
+
+	// Digest B.
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	// Digest A.
+	h.Reset()
+	h.Write(password)
+	h.Write(salt)
+	writeRepeated(h, b, len(password))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	// Sequence P.
+	h.Reset()
+	for i := 0; i < len(password); i++ {
+		h.Write(password)
+	}
+	p := repeatToLength(h.Sum(nil), len(password))
+
+	// Sequence S.
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeatToLength(h.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(c[:0])
+	}
+
+	// Encode the permuted digest by groups of 3 bytes, least significant bits first.
+	var out strings.Builder
+	for i := 0; i < size; i += 3 {
+		var w uint
+		n := 4
+		switch size - i {
+		case 1:
+			w, n = uint(c[order[i]]), 2
+		case 2:
+			w, n = uint(c[order[i]])<<8|uint(c[order[i+1]]), 3
+		default:
+			w = uint(c[order[i]])<<16 | uint(c[order[i+1]])<<8 | uint(c[order[i+2]])
+		}
+		for ; n > 0; n-- {
+			out.WriteByte(shaCryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	return out.String()
+}
+
+// writeRepeated writes data to h repeatedly until n bytes are written.
+func writeRepeated(h hash.Hash, data []byte, n int) {
+	for ; n > len(data); n -= len(data) {
+		h.Write(data)
+	}
+	h.Write(data[:n])
+}
+
+func repeatToLength(data []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out)+len(data) <= n {
+		out = append(out, data...)
+	}
+	return append(out, data[:n-len(out)]...)
+}
@@ -0,0 +1,272 @@
+package vira
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	json "github.com/vira-software/vira/internal"
+	"golang.org/x/sync/singleflight"
+)
+
+// TokenInfoKey is the key the TokenInfo of an introspected token is stored under in the Context.
+const TokenInfoKey = "_vira/token-info"
+
+const (
+	defaultIntrospectionCacheTTL    = 5 * time.Minute
+	defaultIntrospectionNegativeTTL = 30 * time.Second
+	introspectionSweepInterval      = time.Minute
+	introspectionTimeout            = 10 * time.Second
+	maxIntrospectionResponseSize    = 1 << 20
+)
+
+var (
+	// ErrTokenInactive is returned when the introspection endpoint reports a token as inactive.
+	ErrTokenInactive = errors.New("vira: token is not active")
+	// ErrTokenAudience is returned when an introspected token is not intended for the expected audience.
+	ErrTokenAudience = errors.New("vira: invalid token audience")
+)
+
+// TokenInfo is the metadata of an access token returned by an introspection endpoint (RFC 7662).
+type TokenInfo struct {
+	Active    bool        `json:"active"`
+	Scope     string      `json:"scope,omitempty"`
+	ClientID  string      `json:"client_id,omitempty"`
+	Username  string      `json:"username,omitempty"`
+	TokenType string      `json:"token_type,omitempty"`
+	ExpiresAt *JWTTime    `json:"exp,omitempty"`
+	IssuedAt  *JWTTime    `json:"iat,omitempty"`
+	NotBefore *JWTTime    `json:"nbf,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  JWTAudience `json:"aud,omitempty"`
+	Issuer    string      `json:"iss,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+}
+
+// Scopes returns the scopes of the token.
+func (info *TokenInfo) Scopes() []string {
+	return strings.Fields(info.Scope)
+}
+
+// TokenInfo returns the metadata of the token verified by the Introspection middleware, or nil.
+func (c *Context) TokenInfo() *TokenInfo {
+	info, _ := c.Value(TokenInfoKey).(*TokenInfo)
+	return info
+}
+
+// IntrospectionConfig defines the config for Introspection middleware.
+type IntrospectionConfig struct {
+	// URL is the introspection endpoint of the authorization server.
+	// Required.
+	URL string
+
+	// ClientID and ClientSecret authenticate the resource server to the introspection
+	// endpoint, with HTTP Basic authentication.
+	// Optional.
+	ClientID     string
+	ClientSecret string
+
+	// HTTPClient calls the introspection endpoint.
+	// Optional. Default value is http.DefaultClient.
+	HTTPClient *http.Client
+
+	// CacheTTL is the maximum time active tokens are cached. They are never cached past their expiration time.
+	// Optional. Default value is 5 minutes.
+	CacheTTL time.Duration
+
+	// NegativeCacheTTL is how long inactive tokens are cached.
+	// Optional. Default value is 30 seconds.
+	NegativeCacheTTL time.Duration
+
+	// Scopes are the scopes all tokens must have. Use RouterGroup.Authorize with RequireScopes
+	// to require scopes for some routes only.
+	// Optional.
+	Scopes []string
+
+	// Audience is the value the "aud" of the tokens must contain.
+	// Optional. By default, the audience is not checked.
+	Audience string
+
+	// Realm is the realm of the WWW-Authenticate challenge.
+	// Optional.
+	Realm string
+}
+
+// Introspection returns a Bearer token authentication middleware validating opaque access
+// tokens with the introspection endpoint at url. See IntrospectionWithConfig for more details.
+func Introspection(url, clientID, clientSecret string) HandlerFunc {
+	return IntrospectionWithConfig(IntrospectionConfig{URL: url, ClientID: clientID, ClientSecret: clientSecret})
+}
+
+// IntrospectionWithConfig returns an Introspection middleware with config.
+// Tokens are sent to the introspection endpoint (RFC 7662) and the results are cached. Once
+// validated, the TokenInfo is stored in the Context, see Context.TokenInfo, the username (or
+// subject) is set to the AuthUserKey and the scopes to the AuthScopesKey. Invalid tokens are
+// rejected with 401 (Unauthorized), and tokens missing required scopes with 403 (Forbidden),
+// with a WWW-Authenticate header as defined by RFC 6750. When the endpoint cannot be reached,
+// requests are rejected with 503 (Service Unavailable).
+func IntrospectionWithConfig(conf IntrospectionConfig) HandlerFunc {
+	assert1(conf.URL != "", "an introspection URL is required")
+	if conf.HTTPClient == nil {
+		conf.HTTPClient = http.DefaultClient
+	}
+	if conf.CacheTTL <= 0 {
+		conf.CacheTTL = defaultIntrospectionCacheTTL
+	}
+	if conf.NegativeCacheTTL <= 0 {
+		conf.NegativeCacheTTL = defaultIntrospectionNegativeTTL
+	}
+	in := &introspector{conf: conf, cache: make(map[string]introspectionEntry)}
+
+	return func(c *Context) {
+		token, ok := bearerToken(c.requestHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", bearerChallenge(conf.Realm, "", ""))
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		info, err := in.introspect(token)
+		if err != nil {
+			c.AbortWithError(http.StatusServiceUnavailable, err) //nolint: errcheck
+			return
+		}
+		if err := conf.validate(info, time.Now()); err != nil {
+			_ = c.Error(err)
+			c.Header("WWW-Authenticate", bearerChallenge(conf.Realm, "invalid_token", strings.TrimPrefix(err.Error(), "vira: ")))
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set(TokenInfoKey, info)
+		if user := info.Username; user != "" {
+			c.setAuthUser(user)
+		} else if info.Subject != "" {
+			c.setAuthUser(info.Subject)
+		}
+		if scopes := info.Scopes(); len(scopes) > 0 {
+			c.Set(AuthScopesKey, scopes)
+		}
+		if len(conf.Scopes) > 0 {
+			Authorize(RequireScopes(conf.Scopes...))(c)
+		}
+	}
+}
+
+func (conf *IntrospectionConfig) validate(info *TokenInfo, now time.Time) error {
+	if !info.Active || (info.ExpiresAt != nil && !now.Before(info.ExpiresAt.Time)) ||
+		(info.NotBefore != nil && now.Before(info.NotBefore.Time)) {
+		return ErrTokenInactive
+	}
+	if conf.Audience != "" && !info.Audience.Contains(conf.Audience) {
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+type introspector struct {
+	conf  IntrospectionConfig
+	group singleflight.Group
+
+	mu        sync.RWMutex
+	cache     map[string]introspectionEntry
+	lastSweep time.Time
+}
+
+type introspectionEntry struct {
+	info    *TokenInfo
+	expires time.Time
+}
+
+// introspect returns the TokenInfo of token, from the cache or the introspection endpoint.
+func (in *introspector) introspect(token string) (*TokenInfo, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	now := time.Now()
+	in.mu.RLock()
+	entry, ok := in.cache[key]
+	in.mu.RUnlock()
+	if ok && now.Before(entry.expires) {
+		return entry.info, nil
+	}
+
+	v, err, _ := in.group.Do(key, func() (any, error) {
+		info, err := in.fetch(token)
+		if err != nil {
+			return nil, err
+		}
+		in.store(key, info, time.Now())
+		return info, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*TokenInfo), nil
+}
+
+func (in *introspector) store(key string, info *TokenInfo, now time.Time) {
+	expires := now.Add(in.conf.NegativeCacheTTL)
+	if info.Active {
+		expires = now.Add(in.conf.CacheTTL)
+		if info.ExpiresAt != nil && info.ExpiresAt.Before(expires) {
+			expires = info.ExpiresAt.Time
+		}
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.cache[key] = introspectionEntry{info: info, expires: expires}
+	if now.Sub(in.lastSweep) >= introspectionSweepInterval {
+		for k, e := range in.cache {
+			if !now.Before(e.expires) {
+				delete(in.cache, k)
+			}
+		}
+		in.lastSweep = now
+	}
+}
+
+// fetch calls the introspection endpoint. The call is shared by the concurrent requests with
+// the same token, hence it does not depend on the context of any of them.
+func (in *introspector) fetch(token string) (*TokenInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), introspectionTimeout)
+	defer cancel()
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.conf.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if in.conf.ClientID != "" {
+		// The credentials are form-encoded before being used as Basic credentials (RFC 6749, section 2.3.1).
+		req.SetBasicAuth(url.QueryEscape(in.conf.ClientID), url.QueryEscape(in.conf.ClientSecret))
+	}
+
+	resp, err := in.conf.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vira: token introspection: unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxIntrospectionResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	info := &TokenInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("vira: token introspection: %w", err)
+	}
+	return info, nil
+}
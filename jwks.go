@@ -0,0 +1,219 @@
+package vira
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	json "github.com/vira-software/vira/internal"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultJWKSRefreshInterval = time.Hour
+	// jwksMinRefreshInterval limits how often an unknown key id triggers a refresh.
+	jwksMinRefreshInterval = time.Minute
+	jwksFetchTimeout       = 10 * time.Second
+	maxJWKSSize            = 1 << 20
+)
+
+// JWKS is a JWTKeyProvider fetching its keys from a JSON Web Key Set document (RFC 7517),
+// such as the jwks_uri of an OpenID Connect provider. The document is fetched on first use
+// and refreshed periodically, or earlier when a token references an unknown key id. When a
+// refresh fails, the previous keys are kept and the refresh is retried a minute later. RSA,
+// EC P-256 and Ed25519 public keys are supported.
+type JWKS struct {
+	// URL of the JWK Set document.
+	URL string
+
+	// RefreshInterval is how long the keys are cached.
+	// Optional. Default value is 1 hour.
+	RefreshInterval time.Duration
+
+	// HTTPClient fetches the document.
+	// Optional. Default value is http.DefaultClient.
+	HTTPClient *http.Client
+
+	group   singleflight.Group
+	mu      sync.Mutex
+	keys    []JWTKey
+	fetched time.Time
+	retryAt time.Time
+	err     error
+}
+
+var _ JWTKeyProvider = (*JWKS)(nil)
+
+// NewJWKS returns a JWKS fetching its keys from url every refreshInterval.
+func NewJWKS(url string, refreshInterval time.Duration) *JWKS {
+	return &JWKS{URL: url, RefreshInterval: refreshInterval}
+}
+
+// JWTKeys implements JWTKeyProvider.
+func (s *JWKS) JWTKeys(kid string) ([]JWTKey, error) {
+	if s.needsRefresh(kid) {
+		// The document is fetched without holding s.mu, and concurrent callers share the fetch.
+		s.group.Do("", func() (any, error) { //nolint: errcheck
+			if s.needsRefresh(kid) {
+				s.refresh()
+			}
+			return nil, nil
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil && s.err != nil {
+		return nil, s.err
+	}
+	return filterJWTKeys(s.keys, kid), nil
+}
+
+// needsRefresh reports whether the document must be fetched to look kid up.
+func (s *JWKS) needsRefresh(kid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(s.retryAt) {
+		return false
+	}
+	refresh := s.RefreshInterval
+	if refresh <= 0 {
+		refresh = defaultJWKSRefreshInterval
+	}
+	age := now.Sub(s.fetched)
+	return s.fetched.IsZero() || age >= refresh ||
+		(age >= jwksMinRefreshInterval && kid != "" && len(filterJWTKeys(s.keys, kid)) == 0)
+}
+
+// refresh fetches the document, keeping the previous keys on failure. A failed fetch is
+// retried after jwksMinRefreshInterval.
+func (s *JWKS) refresh() {
+	keys, err := s.fetch()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.err = err
+		s.retryAt = time.Now().Add(jwksMinRefreshInterval)
+		debugPrint("[WARNING] Failed to fetch the JWKS from %s: %v", s.URL, err)
+		return
+	}
+	s.keys, s.err = keys, nil
+	s.fetched, s.retryAt = time.Now(), time.Time{}
+}
+
+func (s *JWKS) fetch() ([]JWTKey, error) {
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vira: fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// ParseJWKS parses a JSON Web Key Set document. Keys which are not signature keys or have an
+// unsupported type are ignored.
+func ParseJWKS(data []byte) ([]JWTKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]JWTKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("vira: invalid JWK %q: %w", k.KeyID, err)
+		}
+		if key != nil {
+			keys = append(keys, JWTKey{ID: k.KeyID, Algorithm: k.Algorithm, Key: key})
+		}
+	}
+	return keys, nil
+}
+
+var errJWKParam = errors.New("missing or invalid parameter")
+
+// publicKey returns the public key of k, or nil if its type is not supported.
+func (k *jwk) publicKey() (any, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, e := decodeJWKInt(k.N), decodeJWKInt(k.E)
+		if n == nil || e == nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errJWKParam
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, nil
+		}
+		x, y := decodeJWKInt(k.X), decodeJWKInt(k.Y)
+		if x == nil || y == nil || !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errJWKParam
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errJWKParam
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeJWKInt(s string) *big.Int {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
@@ -0,0 +1,500 @@
+package vira
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	json "github.com/vira-software/vira/internal"
+)
+
+// JWTClaimsKey is the key the claims of a verified JWT are stored under in the Context.
+const JWTClaimsKey = "_vira/jwt-claims"
+
+const defaultJWTClockSkew = time.Minute
+
+var (
+	// ErrJWTMalformed is returned when a token is not a well-formed JWS compact JWT.
+	ErrJWTMalformed = errors.New("vira: malformed JWT")
+	// ErrJWTAlgorithm is returned when a token is signed with an algorithm which is not allowed.
+	ErrJWTAlgorithm = errors.New("vira: JWT algorithm is not allowed")
+	// ErrJWTUnknownKey is returned when no key matches the key id and algorithm of a token.
+	ErrJWTUnknownKey = errors.New("vira: no key found to verify the JWT")
+	// ErrJWTSignature is returned when the signature of a token is invalid.
+	ErrJWTSignature = errors.New("vira: invalid JWT signature")
+	// ErrJWTExpired is returned when a token has expired.
+	ErrJWTExpired = errors.New("vira: JWT has expired")
+	// ErrJWTNotYetValid is returned when a token is used before its "nbf" time.
+	ErrJWTNotYetValid = errors.New("vira: JWT is not valid yet")
+	// ErrJWTIssuer is returned when the issuer of a token is not the expected one.
+	ErrJWTIssuer = errors.New("vira: invalid JWT issuer")
+	// ErrJWTAudience is returned when a token is not intended for the expected audience.
+	ErrJWTAudience = errors.New("vira: invalid JWT audience")
+)
+
+// Supported JWS algorithms.
+const (
+	JWTHS256 = "HS256"
+	JWTHS384 = "HS384"
+	JWTHS512 = "HS512"
+	JWTRS256 = "RS256"
+	JWTPS256 = "PS256"
+	JWTES256 = "ES256"
+	JWTEdDSA = "EdDSA"
+)
+
+// JWTKey is a key verifying the signature of JWTs.
+type JWTKey struct {
+	// ID is matched against the "kid" header of the tokens. A key without ID matches all tokens.
+	ID string
+	// Algorithm restricts the key to a single algorithm. When empty, the key can be used with
+	// all the algorithms of its type.
+	Algorithm string
+	// Key is a []byte for the HS algorithms, an *rsa.PublicKey for RS256 and PS256, an
+	// *ecdsa.PublicKey on the P-256 curve for ES256 and an ed25519.PublicKey for EdDSA.
+	Key any
+}
+
+// JWTKeyProvider provides the keys verifying JWTs. Implementations must be safe for concurrent use.
+type JWTKeyProvider interface {
+	// JWTKeys returns the candidate keys for a token with the given "kid" header, which may be empty.
+	JWTKeys(kid string) ([]JWTKey, error)
+}
+
+// JWTKeys is a static set of keys.
+type JWTKeys []JWTKey
+
+var _ JWTKeyProvider = JWTKeys(nil)
+
+// JWTKeys implements JWTKeyProvider.
+func (keys JWTKeys) JWTKeys(kid string) ([]JWTKey, error) {
+	return filterJWTKeys(keys, kid), nil
+}
+
+func filterJWTKeys(keys []JWTKey, kid string) []JWTKey {
+	if kid == "" {
+		return keys
+	}
+	matching := make([]JWTKey, 0, 1)
+	for _, key := range keys {
+		if key.ID == "" || key.ID == kid {
+			matching = append(matching, key)
+		}
+	}
+	return matching
+}
+
+// JWTClaimer is implemented by claims types, usually by embedding JWTClaims.
+type JWTClaimer interface {
+	RegisteredClaims() *JWTClaims
+}
+
+// JWTClaims are the registered claims of a JWT. Embed it in a struct to decode private claims too:
+//
+//	type MyClaims struct {
+//	    vira.JWTClaims
+//	    Email string `json:"email"`
+//	}
+type JWTClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  JWTAudience `json:"aud,omitempty"`
+	ExpiresAt *JWTTime    `json:"exp,omitempty"`
+	NotBefore *JWTTime    `json:"nbf,omitempty"`
+	IssuedAt  *JWTTime    `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+}
+
+// RegisteredClaims implements JWTClaimer.
+func (claims *JWTClaims) RegisteredClaims() *JWTClaims {
+	return claims
+}
+
+// JWTAudience is the "aud" claim, which is either a single string or an array of strings.
+type JWTAudience []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (aud *JWTAudience) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte{'"'}) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*aud = JWTAudience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*aud = list
+	return nil
+}
+
+// Contains returns true if audience is one of the values of the claim.
+func (aud JWTAudience) Contains(audience string) bool {
+	for _, a := range aud {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTTime is a NumericDate claim, the number of seconds since the Unix epoch.
+type JWTTime struct {
+	time.Time
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *JWTTime) UnmarshalJSON(data []byte) error {
+	f, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return err
+	}
+	sec, frac := int64(f), f-float64(int64(f))
+	t.Time = time.Unix(sec, int64(frac*1e9))
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (t JWTTime) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, t.Unix(), 10), nil
+}
+
+// JWTConfig defines the config for JWTAuth middleware.
+type JWTConfig struct {
+	// Keys provides the keys verifying the tokens, for example JWTKeys or a JWKS.
+	// Required.
+	Keys JWTKeyProvider
+
+	// Algorithms are the accepted signature algorithms.
+	// Optional. By default, all the supported algorithms are accepted, as long as the type
+	// of the key matches the algorithm.
+	Algorithms []string
+
+	// Issuer is the expected "iss" claim.
+	// Optional. By default, the issuer is not checked.
+	Issuer string
+
+	// Audience is the value the "aud" claim must contain.
+	// Optional. By default, the audience is not checked.
+	Audience string
+
+	// ClockSkew is the tolerance when checking the "exp" and "nbf" claims.
+	// Optional. Default value is 1 minute.
+	ClockSkew time.Duration
+
+	// Realm is the realm of the WWW-Authenticate challenge.
+	// Optional.
+	Realm string
+
+	// NewClaims returns the value the claims are decoded into.
+	// Optional. Default value returns a *JWTClaims.
+	NewClaims func() JWTClaimer
+}
+
+// JWT returns the registered claims of the JWT verified by the JWTAuth middleware, or nil.
+// Use JWTClaimsAs to get the claims with their actual type.
+func (c *Context) JWT() *JWTClaims {
+	if claims, ok := c.Value(JWTClaimsKey).(JWTClaimer); ok {
+		return claims.RegisteredClaims()
+	}
+	return nil
+}
+
+// JWTClaimsAs returns the claims of the JWT verified by the JWTAuth middleware, as decoded
+// into the type returned by JWTConfig.NewClaims.
+func JWTClaimsAs[T JWTClaimer](c *Context) (claims T, ok bool) {
+	claims, ok = c.Value(JWTClaimsKey).(T)
+	return
+}
+
+// JWTAuth returns a Bearer token authentication middleware verifying JWTs with keys.
+// See JWTAuthWithConfig for more details.
+func JWTAuth(keys JWTKeyProvider) HandlerFunc {
+	return JWTAuthWithConfig(JWTConfig{Keys: keys})
+}
+
+// JWTAuthWithConfig returns a JWTAuth middleware with config.
+// Requests must carry a JWS compact JWT in their Authorization header, with the Bearer scheme.
+// Once verified, its claims are stored in the Context, see Context.JWT and JWTClaimsAs, and
+// its subject is set to the AuthUserKey, its "roles" claim to the AuthRolesKey and its "scope"
+// or "scp" claim to the AuthScopesKey. Otherwise, the request is aborted with 401 (Unauthorized)
+// and a WWW-Authenticate header describing the error, as defined by RFC 6750.
+func JWTAuthWithConfig(conf JWTConfig) HandlerFunc {
+	assert1(conf.Keys != nil, "JWT keys are required")
+	if conf.ClockSkew <= 0 {
+		conf.ClockSkew = defaultJWTClockSkew
+	}
+	if conf.NewClaims == nil {
+		conf.NewClaims = func() JWTClaimer { return &JWTClaims{} }
+	}
+	allowed := make(map[string]bool)
+	for _, alg := range conf.Algorithms {
+		allowed[alg] = true
+	}
+
+	return func(c *Context) {
+		token, ok := bearerToken(c.requestHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", bearerChallenge(conf.Realm, "", ""))
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		claims := conf.NewClaims()
+		var authz jwtAuthzClaims
+		if err := conf.verify(token, claims, &authz, allowed, time.Now()); err != nil {
+			var keysErr jwtKeysError
+			if errors.As(err, &keysErr) {
+				c.AbortWithError(http.StatusInternalServerError, keysErr.err) //nolint: errcheck
+				return
+			}
+			_ = c.Error(err)
+			c.Header("WWW-Authenticate", bearerChallenge(conf.Realm, "invalid_token", strings.TrimPrefix(err.Error(), "vira: ")))
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set(JWTClaimsKey, claims)
+		if sub := claims.RegisteredClaims().Subject; sub != "" {
+			c.setAuthUser(sub)
+		}
+		if roles := authz.Roles; len(roles) > 0 {
+			c.Set(AuthRolesKey, []string(roles))
+		}
+		if scopes := authz.scopes(); len(scopes) > 0 {
+			c.Set(AuthScopesKey, scopes)
+		}
+	}
+}
+
+// jwtAuthzClaims are the claims conventionally holding the roles and scopes of a token.
+type jwtAuthzClaims struct {
+	Roles JWTAudience `json:"roles"`
+	Scope string      `json:"scope"`
+	Scp   JWTAudience `json:"scp"`
+}
+
+func (claims *jwtAuthzClaims) scopes() []string {
+	scopes := strings.Fields(claims.Scope)
+	for _, scp := range claims.Scp {
+		scopes = append(scopes, strings.Fields(scp)...)
+	}
+	return scopes
+}
+
+// bearerToken extracts the token of an Authorization header with the Bearer scheme.
+func bearerToken(authorization string) (string, bool) {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(authorization[len(prefix):])
+	return token, token != ""
+}
+
+// bearerChallenge returns the value of a WWW-Authenticate header for the Bearer scheme (RFC 6750).
+func bearerChallenge(realm, code, description string, params ...string) string {
+	var attrs []string
+	if realm != "" {
+		attrs = append(attrs, "realm="+strconv.Quote(realm))
+	}
+	if code != "" {
+		attrs = append(attrs, "error="+strconv.Quote(code))
+	}
+	if description != "" {
+		attrs = append(attrs, "error_description="+strconv.Quote(description))
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		attrs = append(attrs, params[i]+"="+strconv.Quote(params[i+1]))
+	}
+	if len(attrs) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(attrs, ", ")
+}
+
+// jwtKeysError is a failure of the JWTKeyProvider, which is not the client's fault.
+type jwtKeysError struct {
+	err error
+}
+
+func (e jwtKeysError) Error() string {
+	return e.err.Error()
+}
+
+type jwtHeader struct {
+	Algorithm string   `json:"alg"`
+	KeyID     string   `json:"kid"`
+	Critical  []string `json:"crit"`
+}
+
+// verify checks the signature and the registered claims of token, and decodes its claims.
+// The roles and scopes are decoded into authz.
+func (conf *JWTConfig) verify(token string, claims JWTClaimer, authz *jwtAuthzClaims, allowed map[string]bool, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrJWTMalformed
+	}
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return ErrJWTMalformed
+	}
+	if len(header.Critical) > 0 {
+		// None of the extensions which can be marked as critical is supported.
+		return ErrJWTMalformed
+	}
+	if _, ok := jwtHashes[header.Algorithm]; !ok || (len(allowed) > 0 && !allowed[header.Algorithm]) {
+		return ErrJWTAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrJWTMalformed
+	}
+
+	keys, err := conf.Keys.JWTKeys(header.KeyID)
+	if err != nil {
+		return jwtKeysError{err}
+	}
+	signed := token[:len(parts[0])+1+len(parts[1])]
+	verified, found := false, false
+	for _, key := range keys {
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			continue
+		}
+		ok, compatible := verifyJWS(header.Algorithm, key.Key, signed, signature)
+		found = found || compatible
+		if ok {
+			verified = true
+			break
+		}
+	}
+	if !found {
+		return ErrJWTUnknownKey
+	}
+	if !verified {
+		return ErrJWTSignature
+	}
+
+	if err := decodeJWTSegment(parts[1], claims); err != nil {
+		return ErrJWTMalformed
+	}
+	// Claims of unexpected types are ignored, as they cannot grant anything.
+	_ = decodeJWTSegment(parts[1], authz)
+	return conf.validate(claims.RegisteredClaims(), now)
+}
+
+// validate checks the registered claims.
+func (conf *JWTConfig) validate(claims *JWTClaims, now time.Time) error {
+	if claims.ExpiresAt != nil && !now.Before(claims.ExpiresAt.Add(conf.ClockSkew)) {
+		return ErrJWTExpired
+	}
+	if claims.NotBefore != nil && now.Add(conf.ClockSkew).Before(claims.NotBefore.Time) {
+		return ErrJWTNotYetValid
+	}
+	if conf.Issuer != "" && claims.Issuer != conf.Issuer {
+		return ErrJWTIssuer
+	}
+	if conf.Audience != "" && !claims.Audience.Contains(conf.Audience) {
+		return ErrJWTAudience
+	}
+	return nil
+}
+
+func decodeJWTSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	JWTHS256: crypto.SHA256,
+	JWTHS384: crypto.SHA384,
+	JWTHS512: crypto.SHA512,
+	JWTRS256: crypto.SHA256,
+	JWTPS256: crypto.SHA256,
+	JWTES256: crypto.SHA256,
+	JWTEdDSA: 0,
+}
+
+func newJWTHash(h crypto.Hash) hash.Hash {
+	switch h {
+	case crypto.SHA384:
+		return sha512.New384()
+	case crypto.SHA512:
+		return sha512.New()
+	default:
+		return sha256.New()
+	}
+}
+
+// verifyJWS verifies the signature of signed with key. compatible is false when the type of
+// key cannot be used with alg, which prevents algorithm confusion attacks.
+func verifyJWS(alg string, key any, signed string, signature []byte) (ok, compatible bool) {
+	h := jwtHashes[alg]
+	digest := func() []byte {
+		hh := newJWTHash(h)
+		hh.Write([]byte(signed))
+		return hh.Sum(nil)
+	}
+
+	switch alg {
+	case JWTHS256, JWTHS384, JWTHS512:
+		secret, isSecret := key.([]byte)
+		if !isSecret {
+			return false, false
+		}
+		mac := hmac.New(func() hash.Hash { return newJWTHash(h) }, secret)
+		mac.Write([]byte(signed))
+		return hmac.Equal(mac.Sum(nil), signature), true
+	case JWTRS256:
+		pub, isRSA := key.(*rsa.PublicKey)
+		if !isRSA {
+			return false, false
+		}
+		return rsa.VerifyPKCS1v15(pub, h, digest(), signature) == nil, true
+	case JWTPS256:
+		pub, isRSA := key.(*rsa.PublicKey)
+		if !isRSA {
+			return false, false
+		}
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: h}
+		return rsa.VerifyPSS(pub, h, digest(), signature, opts) == nil, true
+	case JWTES256:
+		pub, isEC := key.(*ecdsa.PublicKey)
+		if !isEC || pub.Curve != elliptic.P256() {
+			return false, false
+		}
+		if len(signature) != 64 {
+			return false, true
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest(), r, s), true
+	case JWTEdDSA:
+		pub, isEd := key.(ed25519.PublicKey)
+		if !isEd {
+			return false, false
+		}
+		return len(pub) == ed25519.PublicKeySize && ed25519.Verify(pub, []byte(signed), signature), true
+	}
+	return false, false
+}
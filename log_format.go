@@ -0,0 +1,272 @@
+package vira
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	json "github.com/vira-software/vira/internal"
+)
+
+// LogField is a key/value pair of a structured log record.
+type LogField struct {
+	Key   string
+	Value any
+}
+
+// LogFieldNames are the names of the fields of structured access logs. Empty names get
+// their default value, and the fields named "-" are omitted.
+type LogFieldNames struct {
+	Time      string
+	Status    string
+	Latency   string
+	ClientIP  string
+	Method    string
+	Path      string
+	Route     string
+	Proto     string
+	UserAgent string
+	Referer   string
+	RequestID string
+	BytesIn   string
+	BytesOut  string
+	Error     string
+}
+
+// DefaultLogFieldNames are the default names of the fields of structured access logs.
+var DefaultLogFieldNames = LogFieldNames{
+	Time:      "time",
+	Status:    "status",
+	Latency:   "latency",
+	ClientIP:  "client_ip",
+	Method:    "method",
+	Path:      "path",
+	Route:     "route",
+	Proto:     "proto",
+	UserAgent: "user_agent",
+	Referer:   "referer",
+	RequestID: "request_id",
+	BytesIn:   "bytes_in",
+	BytesOut:  "bytes_out",
+	Error:     "error",
+}
+
+func (names LogFieldNames) withDefaults() LogFieldNames {
+	set := func(name *string, value string) {
+		if *name == "" {
+			*name = value
+		}
+	}
+	d := DefaultLogFieldNames
+	set(&names.Time, d.Time)
+	set(&names.Status, d.Status)
+	set(&names.Latency, d.Latency)
+	set(&names.ClientIP, d.ClientIP)
+	set(&names.Method, d.Method)
+	set(&names.Path, d.Path)
+	set(&names.Route, d.Route)
+	set(&names.Proto, d.Proto)
+	set(&names.UserAgent, d.UserAgent)
+	set(&names.Referer, d.Referer)
+	set(&names.RequestID, d.RequestID)
+	set(&names.BytesIn, d.BytesIn)
+	set(&names.BytesOut, d.BytesOut)
+	set(&names.Error, d.Error)
+	return names
+}
+
+// Fields returns the key/value record of the request, named after names, followed by the
+// values of the given Keys of the Context, when they are set. Empty optional values, such as
+// the error or the referer, are omitted.
+func (p *LogFormatterParams) Fields(names LogFieldNames, keys ...string) []LogField {
+	names = names.withDefaults()
+	fields := make([]LogField, 0, 14+len(keys))
+	add := func(name string, value any) {
+		if name == "-" {
+			return
+		}
+		if s, ok := value.(string); ok && s == "" {
+			return
+		}
+		fields = append(fields, LogField{Key: name, Value: value})
+	}
+	add(names.Time, p.TimeStamp)
+	add(names.Status, p.StatusCode)
+	add(names.Latency, p.Latency)
+	add(names.ClientIP, p.ClientIP)
+	add(names.Method, p.Method)
+	add(names.Path, p.Path)
+	add(names.Route, p.Route)
+	if p.Request != nil {
+		add(names.Proto, p.Request.Proto)
+	}
+	add(names.UserAgent, p.UserAgent)
+	add(names.Referer, p.Referer)
+	add(names.RequestID, p.RequestID)
+	add(names.BytesIn, p.BytesIn)
+	add(names.BytesOut, max(p.BodySize, 0))
+	add(names.Error, strings.TrimSpace(p.ErrorMessage))
+	for _, key := range keys {
+		if value, ok := p.Keys[key]; ok {
+			fields = append(fields, LogField{Key: key, Value: value})
+		}
+	}
+	return fields
+}
+
+// LogEncoder appends the encoding of a structured log record, terminated by a newline, to buf.
+type LogEncoder func(buf []byte, fields []LogField) []byte
+
+// JSONLogEncoder encodes the records as JSON objects, one per line. Times are formatted
+// as RFC 3339 strings, and durations as floating point seconds.
+func JSONLogEncoder(buf []byte, fields []LogField) []byte {
+	buf = append(buf, '{')
+	for i, f := range fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONValue(buf, f.Key)
+		buf = append(buf, ':')
+		buf = appendJSONValue(buf, f.Value)
+	}
+	return append(buf, '}', '\n')
+}
+
+func appendJSONValue(buf []byte, value any) []byte {
+	switch v := value.(type) {
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case time.Time:
+		return append(v.AppendFormat(append(buf, '"'), time.RFC3339Nano), '"')
+	case time.Duration:
+		return strconv.AppendFloat(buf, v.Seconds(), 'f', -1, 64)
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return append(buf, data...)
+}
+
+// LogfmtEncoder encodes the records in the logfmt format, i.e. space separated key=value
+// pairs, one record per line. Values with spaces, quotes, equal signs or control characters
+// are quoted.
+func LogfmtEncoder(buf []byte, fields []LogField) []byte {
+	for i, f := range fields {
+		if i > 0 {
+			buf = append(buf, ' ')
+		}
+		buf = append(buf, f.Key...)
+		buf = append(buf, '=')
+		var s string
+		switch v := f.Value.(type) {
+		case string:
+			s = v
+		case int:
+			buf = strconv.AppendInt(buf, int64(v), 10)
+			continue
+		case int64:
+			buf = strconv.AppendInt(buf, v, 10)
+			continue
+		case time.Time:
+			buf = v.AppendFormat(buf, time.RFC3339Nano)
+			continue
+		default:
+			s = fmt.Sprint(v)
+		}
+		if needsLogfmtQuoting(s) {
+			buf = strconv.AppendQuote(buf, s)
+		} else {
+			buf = append(buf, s...)
+		}
+	}
+	return append(buf, '\n')
+}
+
+func needsLogfmtQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// CommonLogFormatter formats the access logs in the Common Log Format of the Apache HTTP
+// Server. The user is the one set to the AuthUserKey, if any.
+func CommonLogFormatter(param LogFormatterParams) string {
+	return string(appendCommonLog(make([]byte, 0, 128), &param)) + "\n"
+}
+
+// CombinedLogFormatter formats the access logs in the Combined Log Format of the Apache HTTP
+// Server, i.e. the Common Log Format followed by the referer and the user agent.
+func CombinedLogFormatter(param LogFormatterParams) string {
+	buf := appendCommonLog(make([]byte, 0, 256), &param)
+	buf = append(buf, ' ')
+	buf = appendCLFQuoted(buf, param.Referer)
+	buf = append(buf, ' ')
+	buf = appendCLFQuoted(buf, param.UserAgent)
+	return string(buf) + "\n"
+}
+
+func appendCommonLog(buf []byte, p *LogFormatterParams) []byte {
+	user, _ := p.Keys[AuthUserKey].(string)
+	proto := "HTTP/1.1"
+	if p.Request != nil {
+		proto = p.Request.Proto
+	}
+
+	buf = append(buf, orDash(p.ClientIP)...)
+	buf = append(buf, " - "...)
+	buf = append(buf, orDash(strings.ReplaceAll(user, " ", "%20"))...)
+	buf = append(buf, " ["...)
+	buf = p.TimeStamp.AppendFormat(buf, "02/Jan/2006:15:04:05 -0700")
+	buf = append(buf, "] "...)
+	buf = appendCLFQuoted(buf, p.Method+" "+p.Path+" "+proto)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(p.StatusCode), 10)
+	buf = append(buf, ' ')
+	if p.BodySize > 0 {
+		return strconv.AppendInt(buf, int64(p.BodySize), 10)
+	}
+	return append(buf, '-')
+}
+
+// appendCLFQuoted appends s as a quoted string, escaping quotes, backslashes and control characters.
+func appendCLFQuoted(buf []byte, s string) []byte {
+	if s == "" {
+		return append(buf, `"-"`...)
+	}
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '"' || ch == '\\':
+			buf = append(buf, '\\', ch)
+		case ch < ' ' || ch == 0x7f:
+			buf = append(buf, fmt.Sprintf(`\x%02x`, ch)...)
+		default:
+			buf = append(buf, ch)
+		}
+	}
+	return append(buf, '"')
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,233 @@
+package vira
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	defaultAsyncQueueSize = 4096
+	asyncWriterBufferSize = 64 << 10
+	defaultLogFileMode    = 0o644
+)
+
+// ErrLogDropped is returned by AsyncWriter.Write when its queue is full.
+var ErrLogDropped = errors.New("vira: log queue is full, entry dropped")
+
+// AsyncWriter writes to an underlying writer from a background goroutine, so that logging
+// does not block the requests. Entries are queued, and dropped when the queue is full, see
+// Dropped. The writes are buffered: call Flush or Close, for example after the graceful
+// shutdown of the server, to write the pending entries.
+// An AsyncWriter is safe for concurrent use.
+type AsyncWriter struct {
+	queue   chan asyncEntry
+	dropped atomic.Uint64
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// asyncEntry is a log entry, or a flush or close request when done is not nil.
+type asyncEntry struct {
+	data  []byte
+	done  chan error
+	close bool
+}
+
+// NewAsyncWriter returns an AsyncWriter writing to w, with a queue of queueSize entries.
+// A queueSize of 0 means 4096 entries.
+func NewAsyncWriter(w io.Writer, queueSize int) *AsyncWriter {
+	if queueSize <= 0 {
+		queueSize = defaultAsyncQueueSize
+	}
+	aw := &AsyncWriter{
+		queue: make(chan asyncEntry, queueSize),
+		done:  make(chan struct{}),
+	}
+	go aw.run(w)
+	return aw
+}
+
+func (aw *AsyncWriter) run(w io.Writer) {
+	defer close(aw.done)
+	buf := bufio.NewWriterSize(w, asyncWriterBufferSize)
+	var err error
+	for entry := range aw.queue {
+		if entry.done != nil {
+			if flushErr := buf.Flush(); err == nil {
+				err = flushErr
+			}
+			if entry.close {
+				if c, ok := w.(io.Closer); ok {
+					if closeErr := c.Close(); err == nil {
+						err = closeErr
+					}
+				}
+				entry.done <- err
+				return
+			}
+			entry.done <- err
+			err = nil
+			continue
+		}
+		if _, werr := buf.Write(entry.data); werr != nil && err == nil {
+			err = werr
+		}
+		if len(aw.queue) == 0 {
+			if flushErr := buf.Flush(); flushErr != nil && err == nil {
+				err = flushErr
+			}
+		}
+	}
+}
+
+// Write queues a copy of p. It returns ErrLogDropped if the queue is full, and
+// os.ErrClosed if the writer is closed.
+func (aw *AsyncWriter) Write(p []byte) (int, error) {
+	aw.mu.RLock()
+	defer aw.mu.RUnlock()
+	if aw.closed {
+		return 0, os.ErrClosed
+	}
+	select {
+	case aw.queue <- asyncEntry{data: append([]byte(nil), p...)}:
+		return len(p), nil
+	default:
+		aw.dropped.Add(1)
+		return 0, ErrLogDropped
+	}
+}
+
+// Dropped returns the number of entries dropped because the queue was full.
+func (aw *AsyncWriter) Dropped() uint64 {
+	return aw.dropped.Load()
+}
+
+// Flush waits until the queued entries are written, and returns the first write error
+// since the previous flush.
+func (aw *AsyncWriter) Flush() error {
+	aw.mu.RLock()
+	if aw.closed {
+		aw.mu.RUnlock()
+		return os.ErrClosed
+	}
+	done := make(chan error, 1)
+	aw.queue <- asyncEntry{done: done}
+	aw.mu.RUnlock()
+	return <-done
+}
+
+// Close writes the queued entries, then closes the underlying writer if it is an io.Closer.
+func (aw *AsyncWriter) Close() error {
+	aw.mu.Lock()
+	if aw.closed {
+		aw.mu.Unlock()
+		return os.ErrClosed
+	}
+	aw.closed = true
+	aw.mu.Unlock()
+
+	done := make(chan error, 1)
+	aw.queue <- asyncEntry{done: done, close: true}
+	err := <-done
+	<-aw.done
+	return err
+}
+
+// LogFile is a log file which can be reopened, so that external tools such as logrotate
+// can rotate it: they rename the file, then ask the application to reopen it, usually with
+// a SIGHUP signal. See ReopenOnSignal.
+// A LogFile is safe for concurrent use.
+type LogFile struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	signals chan os.Signal
+}
+
+// OpenLogFile opens, or creates, the log file at path in append mode.
+func OpenLogFile(path string) (*LogFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, defaultLogFileMode)
+	if err != nil {
+		return nil, err
+	}
+	return &LogFile{path: path, file: f}, nil
+}
+
+// Write implements io.Writer.
+func (lf *LogFile) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.file == nil {
+		return 0, os.ErrClosed
+	}
+	return lf.file.Write(p)
+}
+
+// Reopen closes the file and opens the file at the same path. If it cannot be opened, the
+// current file is kept.
+func (lf *LogFile) Reopen() error {
+	f, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, defaultLogFileMode)
+	if err != nil {
+		return err
+	}
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.file == nil {
+		f.Close()
+		return os.ErrClosed
+	}
+	old := lf.file
+	lf.file = f
+	return old.Close()
+}
+
+// ReopenOnSignal reopens the file whenever the process receives one of the signals, SIGHUP
+// by default, until the file is closed.
+func (lf *LogFile) ReopenOnSignal(sig ...os.Signal) {
+	if len(sig) == 0 {
+		sig = []os.Signal{syscall.SIGHUP}
+	}
+	signals := make(chan os.Signal, 1)
+	lf.mu.Lock()
+	if lf.file == nil || lf.signals != nil {
+		lf.mu.Unlock()
+		return
+	}
+	lf.signals = signals
+	signal.Notify(signals, sig...)
+	lf.mu.Unlock()
+
+	go func() {
+		for range signals {
+			if err := lf.Reopen(); err != nil {
+				debugPrint("[WARNING] Cannot reopen log file %s: %v", lf.path, err)
+			}
+		}
+	}()
+}
+
+// Close stops the signal handling and closes the file.
+func (lf *LogFile) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.file == nil {
+		return os.ErrClosed
+	}
+	if lf.signals != nil {
+		signal.Stop(lf.signals)
+		close(lf.signals)
+		lf.signals = nil
+	}
+	err := lf.file.Close()
+	lf.file = nil
+	return err
+}
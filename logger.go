@@ -1,9 +1,13 @@
 package vira
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -44,6 +48,90 @@ type LoggerConfig struct {
 	// Skip is a Skipper that indicates which logs should not be written.
 	// Optional.
 	Skip Skipper
+
+	// Encoder turns on structured logging: the records returned by LogFormatterParams.Fields
+	// are encoded, for example with JSONLogEncoder or LogfmtEncoder, and written to Output.
+	// Formatter is ignored.
+	// Optional.
+	Encoder LogEncoder
+
+	// Handler turns on structured logging to a log/slog handler: the records are sent to
+	// Handler, with the Info level, Warn for client errors and Error for server errors.
+	// Formatter, Output and Encoder are ignored.
+	// Optional. When none of Formatter, Output, Encoder and Handler are set and Vira.Logger
+	// is, the records are logged with Context.Logger.
+	Handler slog.Handler
+
+	// FieldNames are the names of the fields of the structured records.
+	// Optional. Default value is DefaultLogFieldNames.
+	FieldNames LogFieldNames
+
+	// Keys are the keys of the Context whose values are added to the structured records.
+	// Optional.
+	Keys []string
+
+	// Sampling logs a fraction of the successful requests only.
+	// Optional. By default, all the requests are logged.
+	Sampling *LogSampling
+}
+
+// LogSampling defines which requests are logged. The requests which failed, with a status
+// code of 400 or more or with errors, and the slow requests are always logged.
+type LogSampling struct {
+	// Rate is the fraction of the other requests which are logged, between 0 and 1.
+	Rate float64
+
+	// SlowThreshold is the latency from which requests are considered slow.
+	// Optional. By default, requests are never considered slow.
+	SlowThreshold time.Duration
+}
+
+// sampled returns true if the request should be logged.
+func (s *LogSampling) sampled(c *Context, latency time.Duration) bool {
+	if c.Writer.Status() >= http.StatusBadRequest || len(c.Errors) > 0 {
+		return true
+	}
+	if s.SlowThreshold > 0 && latency >= s.SlowThreshold {
+		return true
+	}
+	return s.Rate >= 1 || (s.Rate > 0 && rand.Float64() < s.Rate)
+}
+
+// Logger returns a logger for the request, derived from Vira.Logger, with the request_id,
+// method, route and client_ip attributes, and the ones added with AddLogAttrs. The
+// authentication middleware add the user_id of the authenticated user.
+func (c *Context) Logger() *slog.Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+	base := slog.Default()
+	if c.engine != nil && c.engine.Logger != nil {
+		base = c.engine.Logger
+	}
+	attrs := make([]any, 0, 8+len(c.logAttrs))
+	if id := c.RequestID(); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if c.Request != nil {
+		attrs = append(attrs, slog.String("method", c.Request.Method))
+		if route := c.FullPath(); route != "" {
+			attrs = append(attrs, slog.String("route", route))
+		}
+		attrs = append(attrs, slog.String("client_ip", c.ClientIP()))
+	}
+	c.logger = base.With(append(attrs, c.logAttrs...)...)
+	return c.logger
+}
+
+// AddLogAttrs adds attributes, as key/value pairs or slog.Attr, to the logger of the request
+// returned by Logger, for the following handlers.
+//
+//	c.AddLogAttrs("tenant", tenant.ID)
+func (c *Context) AddLogAttrs(args ...any) {
+	c.logAttrs = append(c.logAttrs, args...)
+	if c.logger != nil {
+		c.logger = c.logger.With(args...)
+	}
 }
 
 // Skipper is a function to skip logs based on provided Context
@@ -76,6 +164,16 @@ type LogFormatterParams struct {
 	BodySize int
 	// Keys are the keys set on the request's context.
 	Keys map[string]any
+	// Route is the matched route pattern, see Context.FullPath.
+	Route string
+	// UserAgent is the User-Agent header of the request.
+	UserAgent string
+	// Referer is the Referer header of the request.
+	Referer string
+	// RequestID identifies the request, see Context.RequestID.
+	RequestID string
+	// BytesIn is the number of bytes of the request body read by the handlers.
+	BytesIn int64
 }
 
 // StatusCodeColor is the ANSI color for appropriately logging http status code to a terminal.
@@ -203,6 +301,7 @@ func LoggerWithWriter(out io.Writer, notlogged ...string) HandlerFunc {
 
 // LoggerWithConfig instance a Logger middleware with config.
 func LoggerWithConfig(conf LoggerConfig) HandlerFunc {
+	contextLogger := conf.Formatter == nil && conf.Output == nil && conf.Encoder == nil && conf.Handler == nil
 	formatter := conf.Formatter
 	if formatter == nil {
 		formatter = defaultLogFormatter
@@ -232,11 +331,43 @@ func LoggerWithConfig(conf LoggerConfig) HandlerFunc {
 		}
 	}
 
+	// The attributes of the request-scoped loggers are not repeated in the records.
+	contextNames := conf.FieldNames
+	contextNames.Time, contextNames.RequestID, contextNames.Method = "-", "-", "-"
+	contextNames.Route, contextNames.ClientIP = "-", "-"
+	write := func(c *Context, param *LogFormatterParams) {
+		if contextLogger && c.engine != nil && c.engine.Logger != nil {
+			logToHandler(c.Request.Context(), c.Logger().Handler(), param.Fields(contextNames, conf.Keys...), param)
+			return
+		}
+		fmt.Fprint(out, formatter(*param))
+	}
+	if conf.Handler != nil {
+		// The time of the slog records is the timestamp.
+		names := conf.FieldNames
+		names.Time = "-"
+		write = func(c *Context, param *LogFormatterParams) {
+			logToHandler(c.Request.Context(), conf.Handler, param.Fields(names, conf.Keys...), param)
+		}
+	} else if conf.Encoder != nil {
+		write = func(c *Context, param *LogFormatterParams) {
+			buf := logBufferPool.Get().(*[]byte)
+			*buf = conf.Encoder((*buf)[:0], param.Fields(conf.FieldNames, conf.Keys...))
+			out.Write(*buf) //nolint: errcheck
+			logBufferPool.Put(buf)
+		}
+	}
+
 	return func(c *Context) {
 		// Start timer
 		start := time.Now()
 		path := c.Request.URL.Path
 		raw := c.Request.URL.RawQuery
+		var body *countingReader
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			body = &countingReader{ReadCloser: c.Request.Body}
+			c.Request.Body = body
+		}
 
 		// Process request
 		c.Next()
@@ -245,6 +376,9 @@ func LoggerWithConfig(conf LoggerConfig) HandlerFunc {
 		if _, ok := skip[path]; ok || (conf.Skip != nil && conf.Skip(c)) {
 			return
 		}
+		if conf.Sampling != nil && !conf.Sampling.sampled(c, time.Since(start)) {
+			return
+		}
 
 		param := LogFormatterParams{
 			Request: c.Request,
@@ -262,6 +396,13 @@ func LoggerWithConfig(conf LoggerConfig) HandlerFunc {
 		param.ErrorMessage = c.Errors.ByType(ErrorTypePrivate).String()
 
 		param.BodySize = c.Writer.Size()
+		param.Route = c.FullPath()
+		param.UserAgent = c.Request.UserAgent()
+		param.Referer = c.Request.Referer()
+		param.RequestID = c.RequestID()
+		if body != nil {
+			param.BytesIn = body.n
+		}
 
 		if raw != "" {
 			path = path + "?" + raw
@@ -269,6 +410,42 @@ func LoggerWithConfig(conf LoggerConfig) HandlerFunc {
 
 		param.Path = path
 
-		fmt.Fprint(out, formatter(param))
+		write(c, &param)
 	}
 }
+
+var logBufferPool = sync.Pool{New: func() any {
+	buf := make([]byte, 0, 512)
+	return &buf
+}}
+
+// logToHandler sends a structured access log record to a log/slog handler.
+func logToHandler(ctx context.Context, h slog.Handler, fields []LogField, param *LogFormatterParams) {
+	level := slog.LevelInfo
+	switch {
+	case param.StatusCode >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case param.StatusCode >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	}
+	if !h.Enabled(ctx, level) {
+		return
+	}
+	record := slog.NewRecord(param.TimeStamp, level, "request", 0)
+	for _, f := range fields {
+		record.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+	_ = h.Handle(ctx, record)
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
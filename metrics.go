@@ -0,0 +1,564 @@
+package vira
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDurationBuckets are the default buckets of the request duration histograms, in seconds.
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the default buckets of the request and response size histograms, in bytes.
+var DefaultSizeBuckets = []float64{100, 1000, 10_000, 100_000, 1_000_000, 10_000_000}
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// MetricsRegistry holds metrics and exposes them in the Prometheus text format.
+// A MetricsRegistry is safe for concurrent use.
+type MetricsRegistry struct {
+	mu      sync.RWMutex
+	metrics map[string]metricFamily
+}
+
+// DefaultMetricsRegistry is the registry used by Metrics and MetricsHandler by default.
+var DefaultMetricsRegistry = NewMetricsRegistry()
+
+// NewMetricsRegistry returns an empty registry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{metrics: make(map[string]metricFamily)}
+}
+
+// metricFamily is a metric, with its children for each combination of label values.
+type metricFamily interface {
+	writeTo(w *bufio.Writer)
+	// signature describes the type, the labels and the buckets of the metric.
+	signature() string
+}
+
+// metricVec is the set of children of a metric, by label values.
+type metricVec[T any] struct {
+	name, help, typ string
+	labels          []string
+	newChild        func() *T
+
+	mu       sync.RWMutex
+	children map[string]*metricChild[T]
+}
+
+type metricChild[T any] struct {
+	values []string
+	metric *T
+}
+
+func newMetricVec[T any](name, help, typ string, labels []string, newChild func() *T) *metricVec[T] {
+	assert1(metricNameRE.MatchString(name), "invalid metric name: "+name)
+	for _, label := range labels {
+		assert1(labelNameRE.MatchString(label) && !strings.HasPrefix(label, "__") && label != "le",
+			"invalid label name: "+label)
+	}
+	return &metricVec[T]{
+		name: name, help: help, typ: typ, labels: labels,
+		newChild: newChild,
+		children: make(map[string]*metricChild[T]),
+	}
+}
+
+func (v *metricVec[T]) with(values []string) *T {
+	assert1(len(values) == len(v.labels), "wrong number of label values for metric "+v.name)
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child.metric
+	}
+	child = &metricChild[T]{values: append([]string(nil), values...), metric: v.newChild()}
+	v.children[key] = child
+	return child.metric
+}
+
+// sortedChildren returns the children ordered by label values.
+func (v *metricVec[T]) sortedChildren() []*metricChild[T] {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]*metricChild[T], len(keys))
+	for i, key := range keys {
+		children[i] = v.children[key]
+	}
+	v.mu.RUnlock()
+	return children
+}
+
+func (v *metricVec[T]) writeHeader(w *bufio.Writer) {
+	if v.help != "" {
+		w.WriteString("# HELP " + v.name + " ")
+		w.WriteString(helpReplacer.Replace(v.help))
+		w.WriteByte('\n')
+	}
+	w.WriteString("# TYPE " + v.name + " " + v.typ + "\n")
+}
+
+// writeSample writes a sample line, with the label values and an optional extra label.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, label, values[i])
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatMetricValue(value))
+	w.WriteByte('\n')
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+func writeLabel(w *bufio.Writer, label, value string) {
+	w.WriteString(label)
+	w.WriteString(`="`)
+	w.WriteString(labelValueReplacer.Replace(value))
+	w.WriteByte('"')
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// atomicFloat is a float64 updated atomically.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// Counter is a metric which only goes up, such as a number of requests.
+type Counter struct {
+	value atomicFloat
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add adds delta, which must not be negative, to the counter.
+func (c *Counter) Add(delta float64) {
+	assert1(delta >= 0, "counters cannot decrease")
+	c.value.Add(delta)
+}
+
+// Value returns the value of the counter.
+func (c *Counter) Value() float64 {
+	return c.value.Load()
+}
+
+// CounterVec is a Counter partitioned by labels.
+type CounterVec struct {
+	vec *metricVec[Counter]
+}
+
+// With returns the Counter for the label values, in the order of the labels.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.vec.with(values)
+}
+
+func (v *CounterVec) writeTo(w *bufio.Writer) {
+	v.vec.writeHeader(w)
+	for _, child := range v.vec.sortedChildren() {
+		writeSample(w, v.vec.name, v.vec.labels, child.values, "", "", child.metric.Value())
+	}
+}
+
+// Gauge is a metric which can go up and down, such as a number of requests in flight.
+type Gauge struct {
+	value atomicFloat
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+// Add adds delta to the gauge.
+func (g *Gauge) Add(delta float64) {
+	g.value.Add(delta)
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.value.Set(v)
+}
+
+// Value returns the value of the gauge.
+func (g *Gauge) Value() float64 {
+	return g.value.Load()
+}
+
+// GaugeVec is a Gauge partitioned by labels.
+type GaugeVec struct {
+	vec *metricVec[Gauge]
+}
+
+// With returns the Gauge for the label values, in the order of the labels.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.vec.with(values)
+}
+
+func (v *GaugeVec) writeTo(w *bufio.Writer) {
+	v.vec.writeHeader(w)
+	for _, child := range v.vec.sortedChildren() {
+		writeSample(w, v.vec.name, v.vec.labels, child.values, "", "", child.metric.Value())
+	}
+}
+
+// Histogram counts observations, such as request durations, in buckets.
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sum     atomicFloat
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i].Add(1)
+	}
+	h.sum.Add(v)
+	h.count.Add(1)
+}
+
+// HistogramVec is a Histogram partitioned by labels.
+type HistogramVec struct {
+	vec     *metricVec[Histogram]
+	buckets []float64
+}
+
+// With returns the Histogram for the label values, in the order of the labels.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.vec.with(values)
+}
+
+func (v *HistogramVec) writeTo(w *bufio.Writer) {
+	v.vec.writeHeader(w)
+	name := v.vec.name
+	for _, child := range v.vec.sortedChildren() {
+		h := child.metric
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += h.counts[i].Load()
+			writeSample(w, name+"_bucket", v.vec.labels, child.values, "le", formatMetricValue(upper), float64(cumulative))
+		}
+		count := h.count.Load()
+		writeSample(w, name+"_bucket", v.vec.labels, child.values, "le", "+Inf", float64(count))
+		writeSample(w, name+"_sum", v.vec.labels, child.values, "", "", h.sum.Load())
+		writeSample(w, name+"_count", v.vec.labels, child.values, "", "", float64(count))
+	}
+}
+
+func (v *metricVec[T]) signature() string {
+	return v.typ + "{" + strings.Join(v.labels, ",") + "}"
+}
+
+func (v *CounterVec) signature() string { return v.vec.signature() }
+
+func (v *GaugeVec) signature() string { return v.vec.signature() }
+
+func (v *HistogramVec) signature() string {
+	s := make([]string, len(v.buckets))
+	for i, upper := range v.buckets {
+		s[i] = formatMetricValue(upper)
+	}
+	return v.vec.signature() + "[" + strings.Join(s, ",") + "]"
+}
+
+// register registers m under name, and returns it. If the name is already registered, it
+// panics, unless reuse is true and the registered metric has the same signature as m, in which
+// case the registered metric is returned.
+func (r *MetricsRegistry) register(name string, m metricFamily, reuse bool) metricFamily {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if registered, exists := r.metrics[name]; exists {
+		assert1(reuse, "metric "+name+" is already registered")
+		assert1(registered.signature() == m.signature(),
+			"metric "+name+" is already registered with another type, labels or buckets")
+		return registered
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// NewCounter registers a counter partitioned by labels. It panics if the name is invalid or
+// already registered.
+func (r *MetricsRegistry) NewCounter(name, help string, labels ...string) *CounterVec {
+	return r.counter(name, help, false, labels...)
+}
+
+func (r *MetricsRegistry) counter(name, help string, reuse bool, labels ...string) *CounterVec {
+	v := &CounterVec{newMetricVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	return r.register(name, v, reuse).(*CounterVec)
+}
+
+// NewGauge registers a gauge partitioned by labels. It panics if the name is invalid or
+// already registered.
+func (r *MetricsRegistry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	return r.gauge(name, help, false, labels...)
+}
+
+func (r *MetricsRegistry) gauge(name, help string, reuse bool, labels ...string) *GaugeVec {
+	v := &GaugeVec{newMetricVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	return r.register(name, v, reuse).(*GaugeVec)
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, partitioned by
+// labels. It panics if the name is invalid or already registered.
+func (r *MetricsRegistry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return r.histogram(name, help, buckets, false, labels...)
+}
+
+func (r *MetricsRegistry) histogram(name, help string, buckets []float64, reuse bool, labels ...string) *HistogramVec {
+	assert1(len(buckets) > 0, "a histogram needs buckets")
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	if math.IsInf(buckets[len(buckets)-1], 1) {
+		buckets = buckets[:len(buckets)-1]
+	}
+	v := &HistogramVec{vec: newMetricVec(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets))}
+	}), buckets: buckets}
+	return r.register(name, v, reuse).(*HistogramVec)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format, ordered by name.
+func (r *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]metricFamily, len(names))
+	for i, name := range names {
+		families[i] = r.metrics[name]
+	}
+	r.mu.RUnlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range families {
+		m.writeTo(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Handler returns a handler serving the metrics in the Prometheus text exposition format.
+func (r *MetricsRegistry) Handler() HandlerFunc {
+	return func(c *Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		if _, err := r.WriteTo(c.Writer); err != nil {
+			_ = c.Error(err)
+		}
+	}
+}
+
+// MetricsHandler returns a handler serving the metrics of the DefaultMetricsRegistry.
+//
+//	router.GET("/metrics", vira.MetricsHandler())
+func MetricsHandler() HandlerFunc {
+	return DefaultMetricsRegistry.Handler()
+}
+
+// MetricsConfig defines the config for Metrics middleware.
+type MetricsConfig struct {
+	// Registry is the registry the metrics are registered to.
+	// Optional. Default value is DefaultMetricsRegistry.
+	Registry *MetricsRegistry
+
+	// Namespace prefixes the names of the metrics.
+	// Optional. Default value is "http".
+	Namespace string
+
+	// DurationBuckets are the buckets of the request duration histogram, in seconds.
+	// Optional. Default value is DefaultDurationBuckets.
+	DurationBuckets []float64
+
+	// SizeBuckets are the buckets of the request and response size histograms, in bytes.
+	// Optional. Default value is DefaultSizeBuckets.
+	SizeBuckets []float64
+
+	// Skip is a Skipper that indicates which requests should not be measured.
+	// Optional.
+	Skip Skipper
+}
+
+// Metrics returns a middleware recording the metrics of the requests to the
+// DefaultMetricsRegistry. See MetricsWithConfig for more details.
+func Metrics() HandlerFunc {
+	return MetricsWithConfig(MetricsConfig{})
+}
+
+// MetricsWithConfig returns a Metrics middleware with config.
+// It records, with the "http" namespace:
+//
+//	http_requests_total              counter    method, status, route
+//	http_request_duration_seconds    histogram  method, status, route
+//	http_request_size_bytes          histogram  method, status, route
+//	http_response_size_bytes         histogram  method, status, route
+//	http_requests_in_flight          gauge
+//
+// The status label is the class of the status code, such as "2xx", and the route label the
+// route pattern (see Context.FullPath), empty for unmatched requests, so that the number of
+// series stays bounded. The middleware must be registered with Vira.Use, before the routes.
+// Several Metrics middleware with the same registry and namespace, for example on several
+// engines, share the metrics; they must then use the same buckets.
+func MetricsWithConfig(conf MetricsConfig) HandlerFunc {
+	if conf.Registry == nil {
+		conf.Registry = DefaultMetricsRegistry
+	}
+	if conf.Namespace == "" {
+		conf.Namespace = "http"
+	}
+	if len(conf.DurationBuckets) == 0 {
+		conf.DurationBuckets = DefaultDurationBuckets
+	}
+	if len(conf.SizeBuckets) == 0 {
+		conf.SizeBuckets = DefaultSizeBuckets
+	}
+
+	reg, ns := conf.Registry, conf.Namespace+"_"
+	labels := []string{"method", "status", "route"}
+	requests := reg.counter(ns+"requests_total", "Total number of HTTP requests.", true, labels...)
+	duration := reg.histogram(ns+"request_duration_seconds", "Duration of HTTP requests in seconds.",
+		conf.DurationBuckets, true, labels...)
+	requestSize := reg.histogram(ns+"request_size_bytes", "Size of HTTP request bodies in bytes.",
+		conf.SizeBuckets, true, labels...)
+	responseSize := reg.histogram(ns+"response_size_bytes", "Size of HTTP response bodies in bytes.",
+		conf.SizeBuckets, true, labels...)
+	inFlight := reg.gauge(ns+"requests_in_flight", "Number of HTTP requests being served.", true).With()
+
+	return func(c *Context) {
+		if conf.Skip != nil && conf.Skip(c) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		inFlight.Inc()
+		defer inFlight.Dec()
+		var body *countingReader
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			body = &countingReader{ReadCloser: c.Request.Body}
+			c.Request.Body = body
+		}
+
+		c.Next()
+
+		// The bytes read count chunked and compressed bodies, the Content-Length the bodies
+		// which were not read entirely.
+		size := max(c.Request.ContentLength, 0)
+		if body != nil {
+			size = max(size, body.n)
+		}
+
+		values := []string{metricMethod(c.Request.Method), statusClass(c.Writer.Status()), c.FullPath()}
+		requests.With(values...).Inc()
+		duration.With(values...).Observe(time.Since(start).Seconds())
+		requestSize.With(values...).Observe(float64(size))
+		responseSize.With(values...).Observe(float64(max(c.Writer.Size(), 0)))
+	}
+}
+
+// metricMethod returns the method label of a request. Unknown methods are reported as
+// "other", as clients can send arbitrary ones.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// statusClass returns the class of a status code, such as "2xx".
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
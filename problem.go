@@ -0,0 +1,51 @@
+package vira
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/vira-software/vira/render"
+)
+
+var problemOffers = []string{
+	"application/problem+json", MIMEJSON,
+	"application/problem+xml", MIMEXML, MIMEXML2,
+}
+
+// Problem writes problem as Problem Details (RFC 9457), as application/problem+xml if the
+// client prefers XML, as application/problem+json otherwise. The Status of the problem is set
+// to code and, for problems without Type, an empty Title to the text of code. The ID of the
+// request, see Context.RequestID, is added as the "request_id" extension.
+//
+//	c.Problem(http.StatusConflict, render.Problem{
+//		Type:   "https://example.com/problems/out-of-stock",
+//		Title:  "Out of stock",
+//		Detail: "Item 42 is no longer available.",
+//	})
+func (c *Context) Problem(code int, problem render.Problem) {
+	problem.Status = code
+	if problem.Type == "" && problem.Title == "" {
+		problem.Title = http.StatusText(code)
+	}
+	if id := c.RequestID(); id != "" {
+		if _, ok := problem.Extensions["request_id"]; !ok {
+			extensions := make(map[string]any, len(problem.Extensions)+1)
+			for key, value := range problem.Extensions {
+				extensions[key] = value
+			}
+			extensions["request_id"] = id
+			problem.Extensions = extensions
+		}
+	}
+	if strings.Contains(c.NegotiateFormat(problemOffers...), "xml") {
+		c.Render(code, render.ProblemXML{Problem: problem})
+		return
+	}
+	c.Render(code, problem)
+}
+
+// AbortWithProblem calls `Abort()` and then `Problem` internally.
+func (c *Context) AbortWithProblem(code int, problem render.Problem) {
+	c.Abort()
+	c.Problem(code, problem)
+}
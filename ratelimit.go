@@ -0,0 +1,334 @@
+package vira
+
+import (
+	"hash/maphash"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitAlgorithm is the algorithm used to enforce a RateLimit.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to Burst requests, the bucket being refilled at
+	// a constant rate of Limit requests per Period.
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow allows Limit requests in any window of length Period, approximated
+	// from the counts of the current and previous fixed windows.
+	SlidingWindow
+)
+
+// RateLimit defines how many requests are allowed for a key.
+type RateLimit struct {
+	Algorithm RateLimitAlgorithm
+	// Limit is the number of requests allowed per Period.
+	Limit int
+	// Period is the length of the time window of Limit.
+	Period time.Duration
+	// Burst is the capacity of the bucket of TokenBucket. It defaults to Limit.
+	Burst int
+}
+
+func (rl RateLimit) quota() int {
+	if rl.Algorithm == TokenBucket && rl.Burst > 0 {
+		return rl.Burst
+	}
+	return rl.Limit
+}
+
+// RateLimitResult is the outcome of a request against a RateLimit.
+type RateLimitResult struct {
+	// Allowed reports whether the request may proceed.
+	Allowed bool
+	// Limit is the maximum number of requests which can be made at once.
+	Limit int
+	// Remaining is the number of requests left.
+	Remaining int
+	// Reset is the time until the quota is fully restored.
+	Reset time.Duration
+	// RetryAfter is the time to wait before the next request is allowed, when it is denied.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the state of the rate limits. Implementations must be safe for concurrent use.
+type RateLimitStore interface {
+	// Take consumes one request from the quota of key according to limit.
+	Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimitConfig defines the config for RateLimiter middleware.
+type RateLimitConfig struct {
+	RateLimit
+
+	// KeyFunc returns the key requests are counted against.
+	// Optional. Default value is RateLimitByClientIP.
+	KeyFunc func(c *Context) string
+
+	// Store keeps the state of the rate limits.
+	// Optional. Default value is a new MemoryRateLimitStore.
+	Store RateLimitStore
+
+	// Scope namespaces the keys in Store. Rate limiters sharing a store and a scope share their quotas.
+	// Optional. By default, each rate limiter has its own scope.
+	Scope string
+
+	// LimitReached is called when a request is denied.
+	// Optional. By default, the request is aborted with 429 (Too Many Requests).
+	LimitReached HandlerFunc
+
+	// Skip is a Skipper that indicates which requests are not rate limited.
+	// Optional.
+	Skip Skipper
+}
+
+var rateLimitScopes atomic.Uint64
+
+// RateLimitByClientIP counts requests per client IP, see Context.ClientIP.
+func RateLimitByClientIP(c *Context) string {
+	return c.ClientIP()
+}
+
+// RateLimitByHeader counts requests per value of the given request header,
+// falling back to the client IP when the header is missing.
+func RateLimitByHeader(name string) func(c *Context) string {
+	return func(c *Context) string {
+		if v := c.requestHeader(name); v != "" {
+			return "h:" + v
+		}
+		return c.ClientIP()
+	}
+}
+
+// RateLimitByUser counts requests per authenticated user (see AuthUserKey),
+// falling back to the client IP for anonymous requests.
+func RateLimitByUser(c *Context) string {
+	if user := c.GetString(AuthUserKey); user != "" {
+		return "u:" + user
+	}
+	return c.ClientIP()
+}
+
+// RateLimiter returns a middleware allowing limit requests per period and per client IP,
+// with the token bucket algorithm. See RateLimiterWithConfig for more details.
+func RateLimiter(limit int, period time.Duration) HandlerFunc {
+	return RateLimiterWithConfig(RateLimitConfig{
+		RateLimit: RateLimit{Limit: limit, Period: period},
+	})
+}
+
+// RateLimiterWithConfig returns a rate limiting middleware with config.
+// Each middleware enforces its own limit, so different limits can be set per RouterGroup
+// or per route. Responses carry the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset
+// and RateLimit-Policy headers, and denied requests a Retry-After header.
+func RateLimiterWithConfig(conf RateLimitConfig) HandlerFunc {
+	assert1(conf.Limit > 0, "rate limit must be positive")
+	assert1(conf.Period > 0, "rate limit period must be positive")
+	keyFunc := conf.KeyFunc
+	if keyFunc == nil {
+		keyFunc = RateLimitByClientIP
+	}
+	store := conf.Store
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	scope := conf.Scope
+	if scope == "" {
+		scope = "rl" + strconv.FormatUint(rateLimitScopes.Add(1), 10)
+	}
+	limitReached := conf.LimitReached
+	if limitReached == nil {
+		limitReached = func(c *Context) {
+			c.AbortWithStatus(http.StatusTooManyRequests)
+		}
+	}
+	policy := strconv.Itoa(conf.quota()) + ";w=" + strconv.FormatInt(int64(math.Ceil(conf.Period.Seconds())), 10)
+
+	return func(c *Context) {
+		if conf.Skip != nil && conf.Skip(c) {
+			c.Next()
+			return
+		}
+
+		res, err := store.Take(scope+"|"+keyFunc(c), conf.RateLimit, time.Now())
+		if err != nil {
+			// Fail open: an unavailable store must not take the service down.
+			_ = c.Error(err)
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		header.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset), 10))
+		header.Set("RateLimit-Policy", policy)
+		if !res.Allowed {
+			header.Set("Retry-After", strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+			limitReached(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+const (
+	rateLimitShards        = 64
+	rateLimitSweepInterval = time.Minute
+)
+
+// MemoryRateLimitStore is an in-memory RateLimitStore, sharded to reduce lock contention.
+// Idle keys are evicted periodically.
+type MemoryRateLimitStore struct {
+	seed      maphash.Seed
+	shards    [rateLimitShards]rateLimitShard
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+type rateLimitShard struct {
+	mu      sync.Mutex
+	entries map[string]*rateLimitEntry
+}
+
+// rateLimitEntry holds the state of a key for either algorithm.
+type rateLimitEntry struct {
+	// token bucket
+	tokens float64
+	last   time.Time
+
+	// sliding window
+	window    int64
+	count     int
+	prevCount int
+
+	// expires is the time after which the entry holds no useful state anymore.
+	expires time.Time
+}
+
+var _ RateLimitStore = (*MemoryRateLimitStore)(nil)
+
+// NewMemoryRateLimitStore returns an empty MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{seed: maphash.MakeSeed(), lastSweep: time.Now()}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*rateLimitEntry)
+	}
+	return s
+}
+
+// Take implements RateLimitStore.
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	shard := &s.shards[maphash.String(s.seed, key)%rateLimitShards]
+	shard.mu.Lock()
+	e, ok := shard.entries[key]
+	if !ok {
+		e = &rateLimitEntry{tokens: float64(limit.quota()), last: now}
+		shard.entries[key] = e
+	}
+	var res RateLimitResult
+	if limit.Algorithm == SlidingWindow {
+		res = e.takeSlidingWindow(limit, now)
+	} else {
+		res = e.takeTokenBucket(limit, now)
+	}
+	shard.mu.Unlock()
+
+	s.sweep(now)
+	return res, nil
+}
+
+func (e *rateLimitEntry) takeTokenBucket(limit RateLimit, now time.Time) RateLimitResult {
+	capacity := float64(limit.quota())
+	rate := float64(limit.Limit) / limit.Period.Seconds() // tokens per second
+
+	if elapsed := now.Sub(e.last).Seconds(); elapsed > 0 {
+		e.tokens = math.Min(capacity, e.tokens+elapsed*rate)
+		e.last = now
+	}
+	res := RateLimitResult{Limit: int(capacity)}
+	if e.tokens >= 1 {
+		e.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsToDuration((1 - e.tokens) / rate)
+	}
+	res.Remaining = int(e.tokens)
+	res.Reset = secondsToDuration((capacity - e.tokens) / rate)
+	e.expires = now.Add(res.Reset)
+	return res
+}
+
+func (e *rateLimitEntry) takeSlidingWindow(limit RateLimit, now time.Time) RateLimitResult {
+	period := limit.Period
+	window := now.UnixNano() / int64(period)
+	if e.window != window {
+		if e.window == window-1 {
+			e.prevCount = e.count
+		} else {
+			e.prevCount = 0
+		}
+		e.count = 0
+		e.window = window
+	}
+	elapsed := time.Duration(now.UnixNano() - window*int64(period))
+	weight := 1 - float64(elapsed)/float64(period)
+	estimated := float64(e.prevCount)*weight + float64(e.count)
+
+	res := RateLimitResult{Limit: limit.Limit, Reset: period - elapsed}
+	if estimated+1 <= float64(limit.Limit) {
+		e.count++
+		estimated++
+		res.Allowed = true
+	} else if e.count+1 > limit.Limit || e.prevCount == 0 {
+		res.RetryAfter = period - elapsed
+	} else {
+		// Wait until the weight of the previous window has decreased enough.
+		needed := 1 - float64(limit.Limit-1-e.count)/float64(e.prevCount)
+		res.RetryAfter = time.Duration(needed*float64(period)) - elapsed
+	}
+	res.Remaining = int(math.Max(0, float64(limit.Limit)-math.Ceil(estimated)))
+	if e.count > 0 {
+		// The requests of the current window weigh on the next one too.
+		res.Reset += period
+	}
+	e.expires = now.Add(2 * period)
+	return res
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// sweep evicts the idle entries, at most once per rateLimitSweepInterval.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < rateLimitSweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		for key, e := range shard.entries {
+			if now.After(e.expires) {
+				delete(shard.entries, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"runtime"
 	"strings"
 	"time"
+
+	"github.com/vira-software/vira/render"
 )
 
 var (
@@ -26,13 +28,16 @@ var (
 type RecoveryFunc func(c *Context, err any)
 
 // Recovery returns a middleware that recovers from any panics and writes a 500 if there was one.
+// The panics are logged with Context.Logger when Vira.Logger is set, to DefaultErrorWriter otherwise,
+// with the ID of the request, see Context.RequestID.
 func Recovery() HandlerFunc {
-	return RecoveryWithWriter(DefaultErrorWriter)
+	return customRecovery(DefaultErrorWriter, true, defaultHandleRecovery)
 }
 
 // CustomRecovery returns a middleware that recovers from any panics and calls the provided handle func to handle it.
+// The panics are logged with Context.Logger when Vira.Logger is set, to DefaultErrorWriter otherwise.
 func CustomRecovery(handle RecoveryFunc) HandlerFunc {
-	return RecoveryWithWriter(DefaultErrorWriter, handle)
+	return customRecovery(DefaultErrorWriter, true, handle)
 }
 
 // RecoveryWithWriter returns a middleware for a given writer that recovers from any panics and writes a 500 if there was one.
@@ -45,6 +50,12 @@ func RecoveryWithWriter(out io.Writer, recovery ...RecoveryFunc) HandlerFunc {
 
 // CustomRecoveryWithWriter returns a middleware for a given writer that recovers from any panics and calls the provided handle func to handle it.
 func CustomRecoveryWithWriter(out io.Writer, handle RecoveryFunc) HandlerFunc {
+	return customRecovery(out, false, handle)
+}
+
+// customRecovery returns a recovery middleware logging to out, or with Context.Logger if
+// contextLogger is true and Vira.Logger is set.
+func customRecovery(out io.Writer, contextLogger bool, handle RecoveryFunc) HandlerFunc {
 	var logger *log.Logger
 	if out != nil {
 		logger = log.New(out, "\n\n\x1b[31m", log.LstdFlags)
@@ -52,6 +63,12 @@ func CustomRecoveryWithWriter(out io.Writer, handle RecoveryFunc) HandlerFunc {
 	return func(c *Context) {
 		defer func() {
 			if err := recover(); err != nil {
+				// The panics propagated by the Timeout middleware carry the stack of
+				// the handlers, which ran in another goroutine.
+				var trace []byte
+				if p, ok := err.(*timeoutPanic); ok {
+					err, trace = p.value, p.stack
+				}
 				// Check for a broken connection, as it is not really a
 				// condition that warrants a panic stack trace.
 				var brokenPipe bool
@@ -65,8 +82,16 @@ func CustomRecoveryWithWriter(out io.Writer, handle RecoveryFunc) HandlerFunc {
 						}
 					}
 				}
-				if logger != nil {
-					stack := stack(3)
+				if trace == nil && !brokenPipe {
+					trace = stack(3)
+				}
+				if contextLogger && c.engine != nil && c.engine.Logger != nil {
+					if brokenPipe {
+						c.Logger().Warn("connection broken", "error", err)
+					} else {
+						c.Logger().Error("panic recovered", "error", err, "stack", string(trace))
+					}
+				} else if logger != nil {
 					httpRequest, _ := httputil.DumpRequest(c.Request, false)
 					headers := strings.Split(string(httpRequest), "\r\n")
 					for idx, header := range headers {
@@ -76,14 +101,18 @@ func CustomRecoveryWithWriter(out io.Writer, handle RecoveryFunc) HandlerFunc {
 						}
 					}
 					headersToStr := strings.Join(headers, "\r\n")
+					var requestID string
+					if id := c.RequestID(); id != "" {
+						requestID = " (request_id=" + id + ")"
+					}
 					if brokenPipe {
-						logger.Printf("%s\n%s%s", err, headersToStr, reset)
+						logger.Printf("%s%s\n%s%s", err, requestID, headersToStr, reset)
 					} else if IsDebugging() {
-						logger.Printf("[Recovery] %s panic recovered:\n%s\n%s\n%s%s",
-							timeFormat(time.Now()), headersToStr, err, stack, reset)
+						logger.Printf("[Recovery] %s panic recovered%s:\n%s\n%s\n%s%s",
+							timeFormat(time.Now()), requestID, headersToStr, err, trace, reset)
 					} else {
-						logger.Printf("[Recovery] %s panic recovered:\n%s\n%s%s",
-							timeFormat(time.Now()), err, stack, reset)
+						logger.Printf("[Recovery] %s panic recovered%s:\n%s\n%s%s",
+							timeFormat(time.Now()), requestID, err, trace, reset)
 					}
 				}
 				if brokenPipe {
@@ -100,6 +129,10 @@ func CustomRecoveryWithWriter(out io.Writer, handle RecoveryFunc) HandlerFunc {
 }
 
 func defaultHandleRecovery(c *Context, _ any) {
+	if c.engine != nil && c.engine.ProblemDetails && !c.Writer.Written() {
+		c.AbortWithProblem(http.StatusInternalServerError, render.Problem{})
+		return
+	}
 	c.AbortWithStatus(http.StatusInternalServerError)
 }
 
@@ -0,0 +1,200 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	internal "github.com/vira-software/vira/internal"
+)
+
+// ProblemNamespace is the XML namespace of Problem Details documents.
+const ProblemNamespace = "urn:ietf:rfc:7807"
+
+// Problem is a Problem Details object (RFC 9457), rendered as application/problem+json.
+// Use ProblemXML to render it as application/problem+xml.
+type Problem struct {
+	// Type is a URI reference identifying the problem type. An empty Type means "about:blank",
+	// in which case Title should be the text of the status code.
+	Type string `json:"type,omitempty"`
+	// Title is a short, human-readable summary of the problem type.
+	Title string `json:"title,omitempty"`
+	// Status is the HTTP status code.
+	Status int `json:"status,omitempty"`
+	// Detail is a human-readable explanation specific to this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+	// Instance is a URI reference identifying this occurrence of the problem.
+	Instance string `json:"instance,omitempty"`
+	// Extensions are additional members. They cannot override the members above.
+	Extensions map[string]any `json:"-"`
+}
+
+// ProblemXML renders a Problem as application/problem+xml.
+type ProblemXML struct {
+	Problem
+}
+
+var (
+	problemJSONContentType = []string{"application/problem+json"}
+	problemXMLContentType  = []string{"application/problem+xml"}
+
+	problemMembers = map[string]bool{"type": true, "title": true, "status": true, "detail": true, "instance": true}
+)
+
+// MarshalJSON marshals the members of the problem, followed by its extensions.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type members Problem
+	data, err := internal.Marshal(members(p))
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(data[:len(data)-1])
+	for _, key := range p.extensionKeys() {
+		value, err := internal.Marshal(p.Extensions[key])
+		if err != nil {
+			return nil, err
+		}
+		name, _ := internal.Marshal(key)
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalXML marshals the problem as described by the appendix B of RFC 9457: arrays are
+// encoded as sequences of <i> elements, and objects as elements named after their keys. The
+// extensions and object members whose key is not a valid XML name, such as "a b", are omitted.
+func (p Problem) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	start := xml.StartElement{
+		Name: xml.Name{Local: "problem"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: ProblemNamespace}},
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	members := []struct {
+		name  string
+		value any
+		empty bool
+	}{
+		{"type", p.Type, p.Type == ""},
+		{"title", p.Title, p.Title == ""},
+		{"status", p.Status, p.Status == 0},
+		{"detail", p.Detail, p.Detail == ""},
+		{"instance", p.Instance, p.Instance == ""},
+	}
+	for _, m := range members {
+		if !m.empty {
+			if err := encodeProblemXMLValue(e, m.name, m.value); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range p.extensionKeys() {
+		if !validXMLName(key) {
+			continue
+		}
+		if err := encodeProblemXMLValue(e, key, p.Extensions[key]); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func encodeProblemXMLValue(e *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	v := reflect.ValueOf(value)
+	switch {
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeProblemXMLValue(e, "i", v.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			if !validXMLName(key.String()) {
+				continue
+			}
+			if err := encodeProblemXMLValue(e, key.String(), v.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+	default:
+		return e.EncodeElement(value, start)
+	}
+	return e.EncodeToken(start.End())
+}
+
+// validXMLName reports whether name can be used as the name of an element: an XML name
+// without namespace prefix, not reserved by the XML specification.
+func validXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case i > 0 && (r == '-' || r == '.' || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// extensionKeys returns the sorted keys of the extensions, without the standard members.
+func (p Problem) extensionKeys() []string {
+	keys := make([]string, 0, len(p.Extensions))
+	for key := range p.Extensions {
+		if !problemMembers[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Render (Problem) writes the problem as JSON.
+func (r Problem) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	data, err := internal.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// WriteContentType (Problem) writes the application/problem+json ContentType.
+func (r Problem) WriteContentType(w http.ResponseWriter) {
+	writeContentType(w, problemJSONContentType)
+}
+
+// Render (ProblemXML) writes the problem as XML.
+func (r ProblemXML) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	return xml.NewEncoder(w).Encode(r.Problem)
+}
+
+// WriteContentType (ProblemXML) writes the application/problem+xml ContentType.
+func (r ProblemXML) WriteContentType(w http.ResponseWriter) {
+	writeContentType(w, problemXMLContentType)
+}
@@ -2,21 +2,40 @@ package render
 
 import (
 	"io"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 // Reader contains the IO reader and its length, and custom ContentType and other headers.
+// When Request is set and Reader implements io.ReaderAt or io.ReadSeeker, Range and
+// If-Range request headers are honored, including multi-range (multipart/byteranges) responses.
 type Reader struct {
 	ContentType   string
 	ContentLength int64
 	Reader        io.Reader
 	Headers       map[string]string
+
+	// Request is the request being answered. It enables Range handling, which replaces the
+	// status code with 206 or 416: only set it for 200 (OK) responses.
+	Request *http.Request
+	// ModTime is sent as Last-Modified and used to validate If-Range dates.
+	ModTime time.Time
+	// ETag is sent as ETag and used to validate If-Range entity-tags.
+	// It must be a quoted entity-tag, for example `"v1"` or `W/"v1"`.
+	ETag string
 }
 
+var unixEpochTime = time.Unix(0, 0)
+
 // Render (Reader) writes data with custom ContentType and headers.
 func (r Reader) Render(w http.ResponseWriter) (err error) {
 	r.WriteContentType(w)
+	r.writeValidators(w)
+	if src, size, ok := r.rangeSource(); ok {
+		return r.renderRanges(w, src, size)
+	}
 	if r.ContentLength >= 0 {
 		if r.Headers == nil {
 			r.Headers = map[string]string{}
@@ -42,3 +61,105 @@ func (r Reader) writeHeaders(w http.ResponseWriter, headers map[string]string) {
 		}
 	}
 }
+
+// writeValidators writes the ETag and Last-Modified headers if they are known.
+func (r Reader) writeValidators(w http.ResponseWriter) {
+	header := w.Header()
+	if r.ETag != "" && header.Get("ETag") == "" {
+		header.Set("ETag", r.ETag)
+	}
+	if !r.ModTime.IsZero() && !r.ModTime.Equal(unixEpochTime) && header.Get("Last-Modified") == "" {
+		header.Set("Last-Modified", r.ModTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// rangeSource returns the reader as an io.ReaderAt together with the size of the
+// content, if byte ranges can be served from it.
+func (r Reader) rangeSource() (io.ReaderAt, int64, bool) {
+	if r.Request == nil {
+		return nil, 0, false
+	}
+	if ra, ok := r.Reader.(io.ReaderAt); ok && r.ContentLength >= 0 {
+		return ra, r.ContentLength, true
+	}
+	rs, ok := r.Reader.(io.ReadSeeker)
+	if !ok {
+		return nil, 0, false
+	}
+	base, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, false
+	}
+	size := r.ContentLength
+	if size < 0 {
+		end, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, false
+		}
+		if _, err = rs.Seek(base, io.SeekStart); err != nil {
+			return nil, 0, false
+		}
+		size = end - base
+	}
+	return readSeekerAt{rs: rs, base: base}, size, true
+}
+
+// renderRanges writes the ranges requested by the Range header, or the whole
+// content when no (satisfiable) range was requested.
+func (r Reader) renderRanges(w http.ResponseWriter, src io.ReaderAt, size int64) (err error) {
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+
+	var rangeHeader string
+	if method := r.Request.Method; method == http.MethodGet || method == http.MethodHead {
+		if checkIfRange(r.Request, r.ETag, r.ModTime) {
+			rangeHeader = r.Request.Header.Get("Range")
+		}
+	}
+	ranges, err := parseRange(rangeHeader, size)
+	if err != nil {
+		header.Del("Content-Type")
+		header.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return nil
+	}
+	if sumRangesSize(ranges) > size {
+		// The total number of bytes in all the ranges is larger than the
+		// size of the content, so the client is likely misbehaving.
+		// Ignore the ranges and send the whole content instead.
+		ranges = nil
+	}
+
+	switch {
+	case len(ranges) == 0:
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+		r.writeHeaders(w, r.Headers)
+		_, err = io.Copy(w, io.NewSectionReader(src, 0, size))
+		return
+	case len(ranges) == 1:
+		ra := ranges[0]
+		header.Set("Content-Range", ra.contentRange(size))
+		header.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		r.writeHeaders(w, r.Headers)
+		w.WriteHeader(http.StatusPartialContent)
+		_, err = io.Copy(w, io.NewSectionReader(src, ra.start, ra.length))
+		return
+	}
+
+	contentType := header.Get("Content-Type")
+	mw := multipart.NewWriter(w)
+	header.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	header.Set("Content-Length", strconv.FormatInt(rangesMIMESize(ranges, contentType, size), 10))
+	r.writeHeaders(w, r.Headers)
+	w.WriteHeader(http.StatusPartialContent)
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(ra.mimeHeader(contentType, size))
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(part, io.NewSectionReader(src, ra.start, ra.length)); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
@@ -21,6 +21,8 @@ var (
 	_ Render = (*Reader)(nil)
 	_ Render = (*AsciiJSON)(nil)
 	_ Render = (*ProtoBuf)(nil)
+	_ Render = (*Problem)(nil)
+	_ Render = (*ProblemXML)(nil)
 )
 
 func writeContentType(w http.ResponseWriter, value []string) {
@@ -0,0 +1,143 @@
+package vira
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// RequestIDKey is the key the ID of the request is stored under in the Context.
+const RequestIDKey = "_vira/request-id"
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+	maxRequestIDLength     = 128
+)
+
+// crockford is the Crockford's base32 alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewUUIDv7 returns a random, time-ordered UUID version 7 (RFC 9562), such as
+// "01920c5a-7b3e-7c4f-9a2d-5e6f7a8b9c0d".
+func NewUUIDv7() string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	hex.Encode(s[9:13], b[4:6])
+	hex.Encode(s[14:18], b[6:8])
+	hex.Encode(s[19:23], b[8:10])
+	hex.Encode(s[24:], b[10:])
+	s[8], s[13], s[18], s[23] = '-', '-', '-', '-'
+	return string(s[:])
+}
+
+// NewULID returns a random, time-ordered ULID, such as "01J8G5MZ0R7Q3WXV2K9T4B6N8D".
+func NewULID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+
+	// 128 bits are encoded as 26 characters of 5 bits, the first one having only 3 bits.
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	for i := len(s) - 1; i >= 0; i-- {
+		s[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+// ValidRequestID reports whether id is an acceptable request ID: 1 to 128 letters, digits,
+// or any of "-_.:+/=". It rejects the IDs which could forge log entries or headers.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch ch := id[i]; {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-' || ch == '_' || ch == '.' || ch == ':' || ch == '+' || ch == '/' || ch == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type requestIDContextKey struct{}
+
+// RequestIDFromContext returns the ID of the request set by the RequestID middleware in the
+// request context, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// RequestID returns the ID of the request set by the RequestID middleware, or "" without the
+// middleware.
+func (c *Context) RequestID() string {
+	id, _ := c.Value(RequestIDKey).(string)
+	return id
+}
+
+// RequestIDConfig defines the config for RequestID middleware.
+type RequestIDConfig struct {
+	// Header is the request header the ID is read from, and the response header it is written to.
+	// Optional. Default value is "X-Request-ID".
+	Header string
+
+	// Generator generates the IDs of the requests without a valid ID.
+	// Optional. Default value is NewUUIDv7.
+	Generator func() string
+
+	// Validator reports whether an incoming ID is accepted. Return false to always generate
+	// the IDs, for example when the clients are not trusted.
+	// Optional. Default value is ValidRequestID.
+	Validator func(id string) bool
+}
+
+// RequestID returns a middleware identifying the requests with UUIDs version 7.
+// See RequestIDWithConfig for more details.
+func RequestID() HandlerFunc {
+	return RequestIDWithConfig(RequestIDConfig{})
+}
+
+// RequestIDWithConfig returns a RequestID middleware with config.
+// The ID of the request header is kept if it is valid, otherwise a new one is generated. The
+// ID is stored in the Context, see Context.RequestID, and in the request context, see
+// RequestIDFromContext, and written to the response header, so that error responses carry it
+// too. It is picked up by Logger, Context.Logger and Recovery; register the middleware first.
+func RequestIDWithConfig(conf RequestIDConfig) HandlerFunc {
+	if conf.Header == "" {
+		conf.Header = defaultRequestIDHeader
+	}
+	if conf.Generator == nil {
+		conf.Generator = NewUUIDv7
+	}
+	if conf.Validator == nil {
+		conf.Validator = ValidRequestID
+	}
+
+	return func(c *Context) {
+		id := c.requestHeader(conf.Header)
+		if id == "" || !conf.Validator(id) {
+			id = conf.Generator()
+		}
+		c.Set(RequestIDKey, id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDContextKey{}, id))
+		c.Header(conf.Header, id)
+		c.Next()
+	}
+}
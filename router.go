@@ -53,6 +53,9 @@ type RouterGroup struct {
 	basePath string
 	engine   *Vira
 	root     bool
+
+	policies      []Policy
+	denyByDefault bool
 }
 
 var _ IRouter = (*RouterGroup)(nil)
@@ -67,9 +70,11 @@ func (group *RouterGroup) Use(middleware ...HandlerFunc) IRoutes {
 // For example, all the routes that use a common middleware for authorization could be grouped.
 func (group *RouterGroup) Group(relativePath string, handlers ...HandlerFunc) *RouterGroup {
 	return &RouterGroup{
-		Handlers: group.combineHandlers(handlers),
-		basePath: group.calculateAbsolutePath(relativePath),
-		engine:   group.engine,
+		Handlers:      group.combineHandlers(handlers),
+		basePath:      group.calculateAbsolutePath(relativePath),
+		engine:        group.engine,
+		policies:      append([]Policy(nil), group.policies...),
+		denyByDefault: group.denyByDefault,
 	}
 }
 
@@ -81,8 +86,10 @@ func (group *RouterGroup) BasePath() string {
 
 func (group *RouterGroup) handle(httpMethod, relativePath string, handlers HandlersChain) IRoutes {
 	absolutePath := group.calculateAbsolutePath(relativePath)
-	handlers = group.combineHandlers(handlers)
+	policies, guards := group.routePolicies()
+	handlers = group.combineHandlers(append(guards, handlers...))
 	group.engine.addRoute(httpMethod, absolutePath, handlers)
+	group.engine.setRoutePolicies(httpMethod, absolutePath, policies)
 	return group.returnObj()
 }
 
@@ -0,0 +1,325 @@
+package vira
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	json "github.com/vira-software/vira/internal"
+)
+
+// CSPNonceKey is the key the Content-Security-Policy nonce of the current request is stored under in the Context.
+const CSPNonceKey = "_vira/csp-nonce"
+
+// CSPNonceSource is a CSP source replaced by the nonce of each request, for example
+// CSP{}.With("script-src", "'self'", CSPNonceSource).
+const CSPNonceSource = "'nonce'"
+
+// maxCSPReportSize is the maximum size of the body of a CSP violation report.
+const maxCSPReportSize = 64 << 10
+
+// CSP is a Content-Security-Policy. The zero value is an empty policy, and directives are
+// added with With, which returns a new policy.
+type CSP struct {
+	directives []cspDirective
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// With returns a copy of the policy with the given directive set to sources, replacing
+// the previous value of the directive if any.
+func (p CSP) With(directive string, sources ...string) CSP {
+	directive = strings.ToLower(directive)
+	directives := make([]cspDirective, 0, len(p.directives)+1)
+	for _, d := range p.directives {
+		if d.name != directive {
+			directives = append(directives, d)
+		}
+	}
+	directives = append(directives, cspDirective{name: directive, sources: append([]string(nil), sources...)})
+	return CSP{directives: directives}
+}
+
+// IsZero returns true if the policy has no directive.
+func (p CSP) IsZero() bool {
+	return len(p.directives) == 0
+}
+
+// String returns the policy as sent in the header, with CSPNonceSource left as is.
+func (p CSP) String() string {
+	var sb strings.Builder
+	for i, d := range p.directives {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(d.name)
+		for _, source := range d.sources {
+			sb.WriteByte(' ')
+			sb.WriteString(source)
+		}
+	}
+	return sb.String()
+}
+
+// SecureConfig defines the config for Secure middleware. Empty fields are not sent.
+type SecureConfig struct {
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header, which is only sent
+	// over HTTPS, including behind a trusted proxy setting X-Forwarded-Proto.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// ContentTypeNosniff sends "X-Content-Type-Options: nosniff".
+	ContentTypeNosniff bool
+
+	// FrameOptions is the X-Frame-Options header, for example "DENY" or "SAMEORIGIN".
+	FrameOptions string
+
+	// ReferrerPolicy is the Referrer-Policy header.
+	ReferrerPolicy string
+
+	// PermissionsPolicy is the Permissions-Policy header, for example "camera=(), microphone=()".
+	PermissionsPolicy string
+
+	// CrossOriginOpenerPolicy, CrossOriginEmbedderPolicy and CrossOriginResourcePolicy are
+	// the Cross-Origin-Opener-Policy, Cross-Origin-Embedder-Policy and Cross-Origin-Resource-Policy headers.
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+
+	// ContentSecurityPolicy is the Content-Security-Policy header. The CSPNonceSource sources
+	// are replaced by a nonce generated for each request, available with Context.CSPNonce.
+	ContentSecurityPolicy CSP
+
+	// CSPReportOnly sends the policy in the Content-Security-Policy-Report-Only header, so that
+	// violations are reported but not enforced.
+	CSPReportOnly bool
+
+	// Skip is a Skipper that indicates which requests do not get the headers.
+	Skip Skipper
+}
+
+// DefaultSecureConfig is the config used by Secure. Copy and modify it to change a few settings.
+// Cross-Origin-Embedder-Policy is not set, as it prevents loading cross-origin resources
+// which do not opt in explicitly.
+var DefaultSecureConfig = SecureConfig{
+	HSTSMaxAge:                365 * 24 * time.Hour,
+	HSTSIncludeSubdomains:     true,
+	ContentTypeNosniff:        true,
+	FrameOptions:              "DENY",
+	ReferrerPolicy:            "strict-origin-when-cross-origin",
+	PermissionsPolicy:         "camera=(), microphone=(), geolocation=(), payment=()",
+	CrossOriginOpenerPolicy:   "same-origin",
+	CrossOriginResourcePolicy: "same-origin",
+	ContentSecurityPolicy: CSP{}.
+		With("default-src", "'self'").
+		With("script-src", "'self'", CSPNonceSource).
+		With("object-src", "'none'").
+		With("base-uri", "'self'").
+		With("frame-ancestors", "'none'"),
+}
+
+// CSPNonce returns the Content-Security-Policy nonce of the current request, to be set
+// as the nonce attribute of inline scripts and styles. It returns an empty string if the
+// Secure middleware is not in use or its policy has no CSPNonceSource.
+func (c *Context) CSPNonce() string {
+	return c.GetString(CSPNonceKey)
+}
+
+// Secure returns a middleware setting security headers with DefaultSecureConfig.
+func Secure() HandlerFunc {
+	return SecureWithConfig(DefaultSecureConfig)
+}
+
+// SecureWithConfig returns a Secure middleware with config.
+func SecureWithConfig(conf SecureConfig) HandlerFunc {
+	var static [][2]string
+	addStatic := func(name, value string) {
+		if value != "" {
+			static = append(static, [2]string{name, value})
+		}
+	}
+	if conf.ContentTypeNosniff {
+		addStatic("X-Content-Type-Options", "nosniff")
+	}
+	addStatic("X-Frame-Options", conf.FrameOptions)
+	addStatic("Referrer-Policy", conf.ReferrerPolicy)
+	addStatic("Permissions-Policy", conf.PermissionsPolicy)
+	addStatic("Cross-Origin-Opener-Policy", conf.CrossOriginOpenerPolicy)
+	addStatic("Cross-Origin-Embedder-Policy", conf.CrossOriginEmbedderPolicy)
+	addStatic("Cross-Origin-Resource-Policy", conf.CrossOriginResourcePolicy)
+
+	var hsts string
+	if conf.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(conf.HSTSMaxAge/time.Second), 10)
+		if conf.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if conf.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	cspHeader := "Content-Security-Policy"
+	if conf.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	// The policy is split around the nonce placeholders, so that it is cheap to build per request.
+	cspParts := strings.Split(conf.ContentSecurityPolicy.String(), CSPNonceSource)
+
+	return func(c *Context) {
+		if conf.Skip != nil && conf.Skip(c) {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		for _, h := range static {
+			header.Set(h[0], h[1])
+		}
+		if hsts != "" && isHTTPS(c) {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		switch {
+		case conf.ContentSecurityPolicy.IsZero():
+		case len(cspParts) == 1:
+			header.Set(cspHeader, cspParts[0])
+		default:
+			nonce, err := newCSPNonce()
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err) //nolint: errcheck
+				return
+			}
+			c.Set(CSPNonceKey, nonce)
+			header.Set(cspHeader, strings.Join(cspParts, "'nonce-"+nonce+"'"))
+		}
+		c.Next()
+	}
+}
+
+func newCSPNonce() (string, error) {
+	var b [18]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b[:]), nil
+}
+
+// isHTTPS returns true if the request was received over TLS, either directly or by a
+// trusted proxy setting the X-Forwarded-Proto header.
+func isHTTPS(c *Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	if !strings.EqualFold(c.requestHeader("X-Forwarded-Proto"), "https") {
+		return false
+	}
+	remoteIP := net.ParseIP(c.RemoteIP())
+	return remoteIP != nil && c.engine.isTrustedProxy(remoteIP)
+}
+
+// CSPReport is a Content-Security-Policy violation report.
+type CSPReport struct {
+	DocumentURI        string `json:"documentURL"`
+	Referrer           string `json:"referrer"`
+	BlockedURI         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+	ColumnNumber       int    `json:"columnNumber"`
+	StatusCode         int    `json:"statusCode"`
+	Sample             string `json:"sample"`
+}
+
+// legacyCSPReport is a report sent by browsers for the report-uri directive.
+type legacyCSPReport struct {
+	Body struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		StatusCode         int    `json:"status-code"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// CSPReportHandler returns a handler receiving the Content-Security-Policy violation reports,
+// to be registered on the endpoint of the report-uri or report-to directive. It accepts both
+// the application/csp-report format of report-uri and the application/reports+json format of
+// the Reporting API, calls report for each violation and responds with 204 (No Content).
+func CSPReportHandler(report func(c *Context, r CSPReport)) HandlerFunc {
+	assert1(report != nil, "a CSP report func is required")
+	return func(c *Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCSPReportSize+1))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err) //nolint: errcheck
+			return
+		}
+		if len(body) > maxCSPReportSize {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		var reports []CSPReport
+		if c.ContentType() == "application/reports+json" {
+			var batch []struct {
+				Type string    `json:"type"`
+				Body CSPReport `json:"body"`
+			}
+			if err := json.Unmarshal(body, &batch); err != nil {
+				c.AbortWithError(http.StatusBadRequest, err) //nolint: errcheck
+				return
+			}
+			for _, r := range batch {
+				if r.Type == "csp-violation" {
+					reports = append(reports, r.Body)
+				}
+			}
+		} else {
+			var legacy legacyCSPReport
+			if err := json.Unmarshal(body, &legacy); err != nil {
+				c.AbortWithError(http.StatusBadRequest, err) //nolint: errcheck
+				return
+			}
+			b := legacy.Body
+			directive := b.EffectiveDirective
+			if directive == "" {
+				directive = b.ViolatedDirective
+			}
+			reports = append(reports, CSPReport{
+				DocumentURI:        b.DocumentURI,
+				Referrer:           b.Referrer,
+				BlockedURI:         b.BlockedURI,
+				EffectiveDirective: directive,
+				OriginalPolicy:     b.OriginalPolicy,
+				Disposition:        b.Disposition,
+				SourceFile:         b.SourceFile,
+				LineNumber:         b.LineNumber,
+				ColumnNumber:       b.ColumnNumber,
+				StatusCode:         b.StatusCode,
+				Sample:             b.ScriptSample,
+			})
+		}
+
+		for _, r := range reports {
+			report(c, r)
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
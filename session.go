@@ -0,0 +1,369 @@
+package vira
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SessionKey is the key the current Session is stored under in the Context.
+const SessionKey = "_vira/session"
+
+const (
+	defaultSessionCookieName  = "vira_session"
+	defaultSessionIdleTimeout = 30 * time.Minute
+	defaultSessionAbsTimeout  = 24 * time.Hour
+
+	// sessionTouchInterval is how often the last activity time of an unmodified session is saved.
+	sessionTouchInterval = time.Minute
+
+	flashesKey = "_flash"
+)
+
+// SessionRecord is the persisted state of a session.
+type SessionRecord struct {
+	// ID identifies the session. It is regenerated by Session.RegenerateID.
+	ID string `json:"id"`
+	// Values holds the session data.
+	Values map[string]any `json:"values,omitempty"`
+	// CreatedAt is the time the session was created, used for the absolute timeout.
+	CreatedAt time.Time `json:"created"`
+	// LastSeen is the time of the last request of the session, used for the idle timeout.
+	LastSeen time.Time `json:"seen"`
+	// ExpiresAt is the time after which the session is no longer valid.
+	ExpiresAt time.Time `json:"expires"`
+}
+
+// SessionStore persists sessions. Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Load returns the session referenced by the value of the session cookie.
+	// It returns a nil record, and no error, when the session does not exist.
+	Load(c *Context, value string) (*SessionRecord, error)
+	// Save persists the record and returns the value to send in the session cookie.
+	Save(c *Context, record *SessionRecord) (string, error)
+	// Delete removes the session with the given id.
+	Delete(c *Context, id string) error
+}
+
+// SessionConfig defines the config for Sessions middleware.
+type SessionConfig struct {
+	// Store persists the sessions.
+	// Required.
+	Store SessionStore
+
+	// CookieName is the name of the session cookie.
+	// Optional. Default value is "vira_session".
+	CookieName string
+
+	// Path and Domain of the session cookie.
+	// Optional. Default value of Path is "/".
+	Path   string
+	Domain string
+
+	// Insecure removes the Secure attribute from the session cookie. Only use it
+	// for local development over plain HTTP.
+	// Optional. Default value is false.
+	Insecure bool
+
+	// IdleTimeout is how long a session stays valid without any request.
+	// Optional. Default value is 30 minutes.
+	IdleTimeout time.Duration
+
+	// AbsoluteTimeout is how long a session stays valid after its creation, whatever its activity.
+	// Optional. Default value is 24 hours.
+	AbsoluteTimeout time.Duration
+}
+
+// Session is the session of the current request. It is obtained with Context.Session.
+// Changes are saved automatically before the response headers are written.
+type Session struct {
+	mu        sync.Mutex
+	record    *SessionRecord
+	staleID   string
+	isNew     bool
+	modified  bool
+	destroyed bool
+}
+
+// ID returns the identifier of the session.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.ID
+}
+
+// IsNew returns true if the session was created by the current request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Get returns the value for the given key, ie: (value, true).
+// If the value does not exist it returns (nil, false)
+func (s *Session) Get(key string) (value any, exists bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, exists = s.record.Values[key]
+	return
+}
+
+// Set stores a new key/value pair in the session.
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record.Values == nil {
+		s.record.Values = make(map[string]any)
+	}
+	s.record.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes the value for the given key from the session.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.record.Values[key]; ok {
+		delete(s.record.Values, key)
+		s.modified = true
+	}
+}
+
+// Flash adds a flash message to the session. Flash messages are kept until they are read with Flashes.
+func (s *Session) Flash(value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record.Values == nil {
+		s.record.Values = make(map[string]any)
+	}
+	flashes, _ := s.record.Values[flashesKey].([]any)
+	s.record.Values[flashesKey] = append(flashes, value)
+	s.modified = true
+}
+
+// Flashes returns the flash messages of the session and removes them.
+func (s *Session) Flashes() []any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes, ok := s.record.Values[flashesKey].([]any)
+	if !ok {
+		return nil
+	}
+	delete(s.record.Values, flashesKey)
+	s.modified = true
+	return flashes
+}
+
+// RegenerateID gives the session a new identifier while keeping its data, and removes the
+// session stored under the previous one. It should be called when the privilege level of
+// the session changes, for example after a login, to prevent session fixation.
+func (s *Session) RegenerateID() error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew && s.staleID == "" {
+		s.staleID = s.record.ID
+	}
+	s.record.ID = id
+	s.modified = true
+	return nil
+}
+
+// Destroy removes the session from the store and expires the session cookie.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.record.Values = nil
+}
+
+func newSessionID() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
+
+// Session returns the session of the current request.
+// It panics if the Sessions middleware is not in use.
+func (c *Context) Session() *Session {
+	s, ok := c.MustGet(SessionKey).(*Session)
+	assert1(ok, "the session is not a *vira.Session")
+	return s
+}
+
+// Sessions returns a middleware that loads the session of each request from store and makes it
+// available with Context.Session. See SessionsWithConfig for more details.
+func Sessions(store SessionStore) HandlerFunc {
+	return SessionsWithConfig(SessionConfig{Store: store})
+}
+
+// SessionsWithConfig returns a Sessions middleware with config.
+// The session cookie is HttpOnly and Secure, and its SameSite attribute is the one set
+// with Context.SetSameSite, or Lax by default.
+func SessionsWithConfig(conf SessionConfig) HandlerFunc {
+	assert1(conf.Store != nil, "a session store is required")
+	if conf.CookieName == "" {
+		conf.CookieName = defaultSessionCookieName
+	}
+	if conf.Path == "" {
+		conf.Path = "/"
+	}
+	if conf.IdleTimeout <= 0 {
+		conf.IdleTimeout = defaultSessionIdleTimeout
+	}
+	if conf.AbsoluteTimeout <= 0 {
+		conf.AbsoluteTimeout = defaultSessionAbsTimeout
+	}
+
+	return func(c *Context) {
+		now := time.Now()
+		s, err := conf.load(c, now)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err) //nolint: errcheck
+			return
+		}
+		c.Set(SessionKey, s)
+
+		w := &sessionWriter{ResponseWriter: c.Writer}
+		w.commit = func() {
+			if err := conf.save(c, s, time.Now()); err != nil {
+				_ = c.Error(err)
+			}
+		}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+
+		w.commitOnce()
+	}
+}
+
+// load returns the valid session referenced by the session cookie, or a new one.
+func (conf *SessionConfig) load(c *Context, now time.Time) (*Session, error) {
+	if cookie, err := c.Request.Cookie(conf.CookieName); err == nil && cookie.Value != "" {
+		record, err := conf.Store.Load(c, cookie.Value)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			if now.Before(record.ExpiresAt) && now.Sub(record.LastSeen) < conf.IdleTimeout &&
+				now.Sub(record.CreatedAt) < conf.AbsoluteTimeout {
+				return &Session{record: record}, nil
+			}
+			if err := conf.Store.Delete(c, record.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		record: &SessionRecord{ID: id, CreatedAt: now, LastSeen: now},
+		isNew:  true,
+	}, nil
+}
+
+// save persists the session if needed and writes the session cookie.
+func (conf *SessionConfig) save(c *Context, s *Session, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.staleID != "" {
+		if err := conf.Store.Delete(c, s.staleID); err != nil {
+			return err
+		}
+		s.staleID = ""
+	}
+
+	if s.destroyed {
+		if !s.isNew {
+			if err := conf.Store.Delete(c, s.record.ID); err != nil {
+				return err
+			}
+			conf.setCookie(c, "", -1)
+		}
+		return nil
+	}
+
+	if !s.modified && (s.isNew || now.Sub(s.record.LastSeen) < sessionTouchInterval) {
+		return nil
+	}
+
+	record := s.record
+	record.LastSeen = now
+	record.ExpiresAt = now.Add(conf.IdleTimeout)
+	if absolute := record.CreatedAt.Add(conf.AbsoluteTimeout); absolute.Before(record.ExpiresAt) {
+		record.ExpiresAt = absolute
+	}
+	value, err := conf.Store.Save(c, record)
+	if err != nil {
+		return err
+	}
+	conf.setCookie(c, value, int(record.ExpiresAt.Sub(now)/time.Second))
+	s.isNew, s.modified = false, false
+	return nil
+}
+
+func (conf *SessionConfig) setCookie(c *Context, value string, maxAge int) {
+	sameSite := c.sameSite
+	if sameSite == http.SameSiteDefaultMode {
+		sameSite = http.SameSiteLaxMode
+	}
+	c.SetCookieStruct(&http.Cookie{
+		Name:     conf.CookieName,
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     conf.Path,
+		Domain:   conf.Domain,
+		Secure:   !conf.Insecure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+}
+
+// sessionWriter saves the session right before the response headers are written,
+// since the session cookie cannot be sent afterwards.
+type sessionWriter struct {
+	ResponseWriter
+	commit    func()
+	committed bool
+}
+
+func (w *sessionWriter) commitOnce() {
+	if !w.committed {
+		w.committed = true
+		w.commit()
+	}
+}
+
+func (w *sessionWriter) WriteHeaderNow() {
+	w.commitOnce()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	w.commitOnce()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *sessionWriter) WriteString(s string) (int, error) {
+	w.commitOnce()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *sessionWriter) Flush() {
+	w.commitOnce()
+	w.ResponseWriter.Flush()
+}
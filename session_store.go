@@ -0,0 +1,204 @@
+package vira
+
+import (
+	"errors"
+	"hash/maphash"
+	"sync"
+	"time"
+
+	json "github.com/vira-software/vira/internal"
+)
+
+// maxCookieSize is the maximum size of a cookie value that browsers are required to accept.
+const maxCookieSize = 4096
+
+// sessionCookiePurpose binds the values of CookieSessionStore to sessions in the Keyring.
+const sessionCookiePurpose = "vira session"
+
+// ErrSessionTooLarge is returned by CookieSessionStore when the encoded session does not fit in a cookie.
+var ErrSessionTooLarge = errors.New("vira: session is too large to be stored in a cookie")
+
+// CookieSessionStore stores the whole session in the session cookie, encrypted (or signed)
+// with a Keyring, so that no server-side storage is needed. Values are encoded as JSON,
+// hence numbers are read back as float64. Sessions are limited to about 4 KB.
+// Destroying a cookie session expires the cookie, but a copy of the cookie kept by the
+// client stays valid until its timeouts elapse.
+type CookieSessionStore struct {
+	// Keyring holds the keys used to protect the cookie.
+	// Optional. Default value is the engine Keyring.
+	Keyring *Keyring
+
+	// SignOnly signs the session instead of encrypting it, which lets the client read its content.
+	// Optional. Default value is false.
+	SignOnly bool
+}
+
+var _ SessionStore = (*CookieSessionStore)(nil)
+
+func (s *CookieSessionStore) keyring(c *Context) *Keyring {
+	if s.Keyring != nil {
+		return s.Keyring
+	}
+	return c.keyring()
+}
+
+// Load implements SessionStore. A tampered cookie is treated as a missing session.
+func (s *CookieSessionStore) Load(c *Context, value string) (*SessionRecord, error) {
+	var (
+		data string
+		err  error
+	)
+	if s.SignOnly {
+		data, err = s.keyring(c).Verify(sessionCookiePurpose, value)
+	} else {
+		data, err = s.keyring(c).Decrypt(sessionCookiePurpose, value)
+	}
+	if err != nil {
+		return nil, nil
+	}
+	record := &SessionRecord{}
+	if err := json.Unmarshal([]byte(data), record); err != nil {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// Save implements SessionStore.
+func (s *CookieSessionStore) Save(c *Context, record *SessionRecord) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	var value string
+	if s.SignOnly {
+		value = s.keyring(c).Sign(sessionCookiePurpose, string(data))
+	} else if value, err = s.keyring(c).Encrypt(sessionCookiePurpose, string(data)); err != nil {
+		return "", err
+	}
+	if len(value) > maxCookieSize {
+		return "", ErrSessionTooLarge
+	}
+	return value, nil
+}
+
+// Delete implements SessionStore. It is a no-op, the cookie is expired by the Sessions middleware.
+func (s *CookieSessionStore) Delete(*Context, string) error {
+	return nil
+}
+
+const (
+	memorySessionShards        = 16
+	memorySessionSweepInterval = time.Minute
+)
+
+// MemorySessionStore keeps sessions in memory. Expired sessions are evicted when they are
+// loaded and periodically when sessions are saved. Sessions are lost when the process
+// exits and are not shared between instances.
+type MemorySessionStore struct {
+	seed      maphash.Seed
+	shards    [memorySessionShards]memorySessionShard
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+type memorySessionShard struct {
+	mu       sync.RWMutex
+	sessions map[string]*SessionRecord
+}
+
+var _ SessionStore = (*MemorySessionStore)(nil)
+
+// NewMemorySessionStore returns an empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	s := &MemorySessionStore{seed: maphash.MakeSeed(), lastSweep: time.Now()}
+	for i := range s.shards {
+		s.shards[i].sessions = make(map[string]*SessionRecord)
+	}
+	return s
+}
+
+func (s *MemorySessionStore) shard(id string) *memorySessionShard {
+	return &s.shards[maphash.String(s.seed, id)%memorySessionShards]
+}
+
+// Load implements SessionStore.
+func (s *MemorySessionStore) Load(_ *Context, id string) (*SessionRecord, error) {
+	shard := s.shard(id)
+	shard.mu.RLock()
+	record, ok := shard.sessions[id]
+	shard.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	if !time.Now().Before(record.ExpiresAt) {
+		shard.mu.Lock()
+		delete(shard.sessions, id)
+		shard.mu.Unlock()
+		return nil, nil
+	}
+	return record.clone(), nil
+}
+
+// Save implements SessionStore. The cookie value is the session id.
+func (s *MemorySessionStore) Save(_ *Context, record *SessionRecord) (string, error) {
+	shard := s.shard(record.ID)
+	shard.mu.Lock()
+	shard.sessions[record.ID] = record.clone()
+	shard.mu.Unlock()
+	s.sweep(time.Now())
+	return record.ID, nil
+}
+
+// Delete implements SessionStore.
+func (s *MemorySessionStore) Delete(_ *Context, id string) error {
+	shard := s.shard(id)
+	shard.mu.Lock()
+	delete(shard.sessions, id)
+	shard.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of sessions in the store, including expired ones which have not been evicted yet.
+func (s *MemorySessionStore) Len() (n int) {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		n += len(shard.sessions)
+		shard.mu.RUnlock()
+	}
+	return
+}
+
+// sweep evicts the expired sessions, at most once per memorySessionSweepInterval.
+func (s *MemorySessionStore) sweep(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < memorySessionSweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		for id, record := range shard.sessions {
+			if !now.Before(record.ExpiresAt) {
+				delete(shard.sessions, id)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// clone returns a copy of the record, so that stored records are not shared between requests.
+func (r *SessionRecord) clone() *SessionRecord {
+	cp := *r
+	if r.Values != nil {
+		cp.Values = make(map[string]any, len(r.Values))
+		for k, v := range r.Values {
+			cp.Values[k] = v
+		}
+	}
+	return &cp
+}
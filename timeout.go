@@ -0,0 +1,387 @@
+package vira
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// timeoutKey is the key the timeoutState of a request is stored under in the Context.
+const timeoutKey = "_vira/timeout"
+
+// TimeoutConfig defines the config for Timeout middleware.
+type TimeoutConfig struct {
+	// Timeout is the maximum duration of the request, measured from the moment the
+	// outermost Timeout middleware starts.
+	// Required.
+	Timeout time.Duration
+
+	// StatusCode is the status of the response sent when the timeout is reached.
+	// Optional. Default value is 503 (Service Unavailable).
+	StatusCode int
+
+	// Response is called to write the response when the timeout is reached, instead of
+	// aborting with StatusCode.
+	// Optional.
+	Response HandlerFunc
+
+	// Skip is a Skipper that indicates which requests are not subject to the timeout.
+	// Optional.
+	Skip Skipper
+}
+
+// Timeout returns a middleware that aborts the request with 503 (Service Unavailable) when
+// the remaining handlers take longer than timeout. See TimeoutWithConfig for more details.
+func Timeout(timeout time.Duration) HandlerFunc {
+	return TimeoutWithConfig(TimeoutConfig{Timeout: timeout})
+}
+
+// TimeoutWithConfig returns a Timeout middleware with config.
+//
+// The remaining handlers run in their own goroutine, on a copy of the Context whose request
+// context is canceled when the deadline passes; long running handlers should watch
+// c.Request.Context().Done(), and reading the request body fails once the deadline has
+// passed. Their response is buffered and only sent if they return in
+// time, otherwise it is discarded and the timeout response is sent instead. Consequently,
+// streaming responses are delayed until the handlers return, and hijacking the connection
+// is not supported. Keys and errors set by the handlers are copied back to the Context when
+// they return in time, and panics are propagated to the middlewares registered before; panics
+// happening after the timeout are logged.
+//
+// A Timeout nested in another one, for example set on a RouterGroup while the engine has its
+// own, overrides its duration instead of adding a new deadline.
+func TimeoutWithConfig(conf TimeoutConfig) HandlerFunc {
+	assert1(conf.Timeout > 0, "timeout must be positive")
+	if conf.StatusCode == 0 {
+		conf.StatusCode = http.StatusServiceUnavailable
+	}
+	response := conf.Response
+	if response == nil {
+		response = func(c *Context) {
+			c.AbortWithStatus(conf.StatusCode)
+		}
+	}
+
+	return func(c *Context) {
+		if conf.Skip != nil && conf.Skip(c) {
+			c.Next()
+			return
+		}
+		if st, ok := c.Value(timeoutKey).(*timeoutState); ok {
+			st.setTimeout(conf.Timeout)
+			c.Next()
+			return
+		}
+
+		st := newTimeoutState(c.Request.Context(), conf.Timeout)
+		tw := &timeoutWriter{
+			header: c.Writer.Header().Clone(),
+			status: c.Writer.Status(),
+			size:   noWritten,
+		}
+		cp := c.Copy()
+		cp.Request = c.Request.WithContext(st.ctx)
+		if body := cp.Request.Body; body != nil && body != http.NoBody {
+			cp.Request.Body = &timeoutBody{ReadCloser: body, ctx: st.ctx}
+		}
+		cp.Writer = tw
+		cp.handlers = c.handlers
+		cp.index = c.index
+		cp.Errors = append(cp.Errors, c.Errors...)
+		cp.Accepted = c.Accepted
+		cp.sameSite = c.sameSite
+		cp.Keys[timeoutKey] = st
+
+		finished := make(chan *timeoutPanic, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					finished <- &timeoutPanic{value: p, stack: stack(3)}
+					return
+				}
+				finished <- nil
+			}()
+			cp.Next()
+		}()
+
+		select {
+		case p := <-finished:
+			st.stop()
+			if p != nil {
+				if p.value == http.ErrAbortHandler {
+					panic(p.value)
+				}
+				// Recovery unwraps the panic and logs the stack of the handlers.
+				panic(p)
+			}
+			delete(cp.Keys, timeoutKey)
+			c.mu.Lock()
+			c.Keys = cp.Keys
+			c.mu.Unlock()
+			c.Errors = cp.Errors
+			c.index = cp.index
+			c.logger, c.logAttrs = cp.logger, cp.logAttrs
+			tw.flushTo(c.Writer)
+		case <-st.ctx.Done():
+			tw.discard()
+			_ = c.Error(http.ErrHandlerTimeout)
+			response(c)
+			c.Abort()
+			go func() {
+				if p := <-finished; p != nil {
+					p.log(cp)
+				}
+			}()
+		}
+	}
+}
+
+// timeoutPanic is a panic of the handlers run by the Timeout middleware, with its stack. It
+// is propagated as is to the middlewares registered before the Timeout middleware.
+type timeoutPanic struct {
+	value any
+	stack []byte
+}
+
+// String returns the panic value followed by the stack of the handlers, as printed by
+// net/http when no Recovery middleware is registered.
+func (p *timeoutPanic) String() string {
+	return fmt.Sprintf("%v\n%s", p.value, p.stack)
+}
+
+// log logs a panic of handlers which returned after the timeout: the timeout response has
+// been sent and the panic cannot be propagated to the Recovery middleware anymore. It is
+// logged as Recovery does, with Context.Logger when Vira.Logger is set, to DefaultErrorWriter
+// otherwise.
+func (p *timeoutPanic) log(c *Context) {
+	if c.engine != nil && c.engine.Logger != nil {
+		c.Logger().Error("panic recovered after timeout", "error", p.value, "stack", string(p.stack))
+		return
+	}
+	if DefaultErrorWriter != nil {
+		logger := log.New(DefaultErrorWriter, "\n\n\x1b[31m", log.LstdFlags)
+		logger.Printf("[Recovery] %s panic recovered after timeout:\n%s\n%s%s",
+			timeFormat(time.Now()), p.value, p.stack, reset)
+	}
+}
+
+// timeoutBody is the request body of the handlers run by the Timeout middleware. Once the
+// deadline has passed, the server may be done with the request, so reads fail with the
+// error of the request context.
+type timeoutBody struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// timeoutState holds the deadline of a request. The deadline can be changed by nested
+// Timeout middlewares until it is reached.
+type timeoutState struct {
+	mu       sync.Mutex
+	start    time.Time
+	deadline time.Time
+	timer    *time.Timer
+	gen      int
+	expired  bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newTimeoutState(parent context.Context, timeout time.Duration) *timeoutState {
+	st := &timeoutState{start: time.Now()}
+	ctx, cancel := context.WithCancel(parent)
+	st.ctx, st.cancel = &timeoutContext{Context: ctx, st: st}, cancel
+	st.setTimeout(timeout)
+	return st
+}
+
+// setTimeout sets the deadline to start+timeout, unless it has already been reached.
+func (st *timeoutState) setTimeout(timeout time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.expired {
+		return
+	}
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+	st.gen++
+	gen := st.gen
+	st.deadline = st.start.Add(timeout)
+	st.timer = time.AfterFunc(time.Until(st.deadline), func() {
+		st.mu.Lock()
+		if gen != st.gen {
+			// The deadline was changed, or the handlers returned.
+			st.mu.Unlock()
+			return
+		}
+		st.expired = true
+		st.mu.Unlock()
+		st.cancel()
+	})
+}
+
+// stop releases the resources of st once the handlers have returned.
+func (st *timeoutState) stop() {
+	st.mu.Lock()
+	st.gen++
+	st.timer.Stop()
+	st.mu.Unlock()
+	st.cancel()
+}
+
+// timeoutContext reports the deadline of its timeoutState, which may change over time.
+type timeoutContext struct {
+	context.Context
+	st *timeoutState
+}
+
+func (ctx *timeoutContext) Deadline() (time.Time, bool) {
+	ctx.st.mu.Lock()
+	defer ctx.st.mu.Unlock()
+	return ctx.st.deadline, true
+}
+
+func (ctx *timeoutContext) Err() error {
+	err := ctx.Context.Err()
+	if err == nil {
+		return nil
+	}
+	ctx.st.mu.Lock()
+	defer ctx.st.mu.Unlock()
+	if ctx.st.expired {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// timeoutWriter buffers the response of the handlers run by the Timeout middleware.
+// Once discarded, writes fail with http.ErrHandlerTimeout.
+type timeoutWriter struct {
+	mu        sync.Mutex
+	header    http.Header
+	buf       bytes.Buffer
+	status    int
+	size      int
+	discarded bool
+}
+
+var _ ResponseWriter = (*timeoutWriter)(nil)
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if code > 0 && w.size == noWritten {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size == noWritten {
+		w.size = 0
+	}
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.discarded {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.size == noWritten {
+		w.size = 0
+	}
+	n, err := w.buf.Write(data)
+	w.size += n
+	return n, err
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+func (w *timeoutWriter) Written() bool {
+	return w.Size() != noWritten
+}
+
+// Flush is a no-op: the response is sent once the handlers return.
+func (w *timeoutWriter) Flush() {
+	w.WriteHeaderNow()
+}
+
+// Hijack implements the http.Hijacker interface. It always fails.
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+// CloseNotify implements the http.CloseNotifier interface. The returned channel never
+// receives; watch the request context instead.
+func (w *timeoutWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *timeoutWriter) Pusher() http.Pusher {
+	return nil
+}
+
+// discard drops the buffered response and makes subsequent writes fail.
+func (w *timeoutWriter) discard() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.discarded = true
+	w.buf.Reset()
+}
+
+// flushTo copies the buffered response to dst.
+func (w *timeoutWriter) flushTo(dst ResponseWriter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	header := dst.Header()
+	for k := range header {
+		if _, ok := w.header[k]; !ok {
+			delete(header, k)
+		}
+	}
+	for k, v := range w.header {
+		header[k] = v
+	}
+	dst.WriteHeader(w.status)
+	if w.size != noWritten {
+		dst.WriteHeaderNow()
+	}
+	if w.buf.Len() > 0 {
+		_, _ = io.Copy(dst, &w.buf)
+	}
+}
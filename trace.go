@@ -0,0 +1,586 @@
+package vira
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanKey is the key the server Span of a request is stored under in the Context.
+const SpanKey = "_vira/span"
+
+const (
+	defaultTraceBatchSize     = 512
+	defaultTraceQueueSize     = 2048
+	defaultTraceFlushInterval = 5 * time.Second
+	maxTraceStateLength       = 512
+)
+
+// ErrInvalidTraceparent is returned when a traceparent header is malformed.
+var ErrInvalidTraceparent = errors.New("vira: invalid traceparent")
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// IsValid returns true if the ID is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// String returns the lowercase hex encoding of the ID.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span.
+type SpanID [8]byte
+
+// IsValid returns true if the ID is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// String returns the lowercase hex encoding of the ID.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span propagated across services, as defined by W3C Trace Context.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+	// Remote is true if the span context was received from another service.
+	Remote bool
+}
+
+// IsValid returns true if the span context has a trace and a span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent returns the traceparent header value of the span context.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. Versions after 00 are parsed as
+// version 00, as required by the specification.
+func ParseTraceparent(s string) (SpanContext, error) {
+	const size = 55 // 00-<32 hex>-<16 hex>-<2 hex>
+	if len(s) < size || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	version, ok := parseLowerHex(s[:2])
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(s) != size) || (len(s) > size && s[size] != '-') {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	var sc SpanContext
+	traceID, ok1 := parseLowerHex(s[3:35])
+	spanID, ok2 := parseLowerHex(s[36:52])
+	flags, ok3 := parseLowerHex(s[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&0x01 != 0
+	sc.Remote = true
+	return sc, nil
+}
+
+func parseLowerHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if ch := s[i]; (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// SpanKind is the role of a span in a trace.
+type SpanKind int
+
+// Span kinds, with the values of OpenTelemetry.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// SpanStatus is the status of a span.
+type SpanStatus int
+
+// Span statuses, with the values of OpenTelemetry.
+const (
+	SpanStatusUnset SpanStatus = 0
+	SpanStatusOK    SpanStatus = 1
+	SpanStatusError SpanStatus = 2
+)
+
+// SpanAttribute is a key/value pair describing a span or an event. Values are strings,
+// booleans, integers or floating point numbers.
+type SpanAttribute struct {
+	Key   string
+	Value any
+}
+
+// SpanEvent is an event which happened during a span, such as an error.
+type SpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes []SpanAttribute
+}
+
+// Span is a timed operation of a trace. The exported fields must only be read once the
+// span has ended, typically by a SpanExporter; use the methods to record the span.
+type Span struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanContext
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    []SpanAttribute
+	Events        []SpanEvent
+	Status        SpanStatus
+	StatusMessage string
+	// ServiceName is the name of the service of the Tracer which started the span.
+	ServiceName string
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+// IsRecording returns true if the span is sampled and has not ended. Spans which are not
+// sampled are only propagated: their attributes and events are discarded.
+func (s *Span) IsRecording() bool {
+	if !s.lock() {
+		return false
+	}
+	s.mu.Unlock()
+	return true
+}
+
+// lock locks the span and returns true if it is recording, in which case the caller must
+// unlock it. The span cannot end, and be read by the exporter, while it is locked.
+func (s *Span) lock() bool {
+	if s == nil || !s.SpanContext.Sampled {
+		return false
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// SetAttributes sets attributes of the span, as key/value pairs.
+func (s *Span) SetAttributes(attrs ...SpanAttribute) {
+	if !s.lock() {
+		return
+	}
+	defer s.mu.Unlock()
+next:
+	for _, attr := range attrs {
+		for i := range s.Attributes {
+			if s.Attributes[i].Key == attr.Key {
+				s.Attributes[i].Value = attr.Value
+				continue next
+			}
+		}
+		s.Attributes = append(s.Attributes, attr)
+	}
+}
+
+// AddEvent records an event.
+func (s *Span) AddEvent(name string, attrs ...SpanAttribute) {
+	if !s.lock() {
+		return
+	}
+	defer s.mu.Unlock()
+	s.Events = append(s.Events, SpanEvent{Name: name, Time: time.Now(), Attributes: attrs})
+}
+
+// RecordError records err as an "exception" event.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.AddEvent("exception",
+		SpanAttribute{Key: "exception.type", Value: fmt.Sprintf("%T", err)},
+		SpanAttribute{Key: "exception.message", Value: err.Error()})
+}
+
+// SetStatus sets the status of the span. The message is only kept for SpanStatusError.
+func (s *Span) SetStatus(status SpanStatus, message string) {
+	if !s.lock() {
+		return
+	}
+	defer s.mu.Unlock()
+	s.Status = status
+	if status == SpanStatusError {
+		s.StatusMessage = message
+	} else {
+		s.StatusMessage = ""
+	}
+}
+
+// End ends the span and queues it for export. Calls after the first one are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	if s.SpanContext.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx holding span, so that the spans started from it are
+// its children.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span held by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers of an outgoing request
+// to propagate the span held by ctx.
+//
+//	req, _ := http.NewRequestWithContext(c, http.MethodGet, url, nil)
+//	vira.InjectTraceContext(c, req.Header)
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil || !span.SpanContext.IsValid() {
+		return
+	}
+	header.Set("traceparent", span.SpanContext.Traceparent())
+	if ts := span.SpanContext.TraceState; ts != "" {
+		header.Set("tracestate", ts)
+	} else {
+		header.Del("tracestate")
+	}
+}
+
+// Span returns the server span of the request started by the Tracing middleware, or nil.
+func (c *Context) Span() *Span {
+	span, _ := c.Value(SpanKey).(*Span)
+	return span
+}
+
+// SpanExporter sends ended spans to a tracing backend.
+type SpanExporter interface {
+	// ExportSpans exports a batch of spans.
+	ExportSpans(ctx context.Context, spans []*Span) error
+	// Shutdown releases the resources of the exporter.
+	Shutdown(ctx context.Context) error
+}
+
+// TracerConfig defines the config of a Tracer.
+type TracerConfig struct {
+	// ServiceName is the name of the service, reported with the spans.
+	// Required.
+	ServiceName string
+
+	// Exporter exports the ended spans.
+	// Required.
+	Exporter SpanExporter
+
+	// BatchSize is the maximum number of spans exported at once.
+	// Optional. Default value is 512.
+	BatchSize int
+
+	// QueueSize is the maximum number of spans waiting for export. Spans ended while the
+	// queue is full are dropped.
+	// Optional. Default value is 2048.
+	QueueSize int
+
+	// FlushInterval is the maximum time spans wait for export.
+	// Optional. Default value is 5 seconds.
+	FlushInterval time.Duration
+}
+
+// Tracer starts spans and exports them by batches, from a background goroutine.
+// Call Shutdown, after the graceful shutdown of the server, to export the pending spans.
+// A Tracer is safe for concurrent use.
+type Tracer struct {
+	conf    TracerConfig
+	queue   chan *Span
+	flushes chan chan error
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewTracer returns a Tracer with config.
+func NewTracer(conf TracerConfig) *Tracer {
+	assert1(conf.ServiceName != "", "a service name is required")
+	assert1(conf.Exporter != nil, "a span exporter is required")
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultTraceBatchSize
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultTraceQueueSize
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = defaultTraceFlushInterval
+	}
+	t := &Tracer{
+		conf:    conf,
+		queue:   make(chan *Span, conf.QueueSize),
+		flushes: make(chan chan error),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span, child of the span held by ctx if any, and returns a copy of ctx
+// holding the new span. The span must be ended with End.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	var parent SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.SpanContext
+	}
+	span := t.start(parent, name, kind)
+	return ContextWithSpan(ctx, span), span
+}
+
+// start starts a span, child of parent if it is valid. Root spans are sampled, the
+// children follow the decision of their parent.
+func (t *Tracer) start(parent SpanContext, name string, kind SpanKind) *Span {
+	span := &Span{
+		Name:        name,
+		Kind:        kind,
+		StartTime:   time.Now(),
+		ServiceName: t.conf.ServiceName,
+		tracer:      t,
+	}
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		span.Parent = parent
+		sc.TraceID, sc.Sampled, sc.TraceState = parent.TraceID, parent.Sampled, parent.TraceState
+	} else {
+		_, _ = rand.Read(sc.TraceID[:])
+	}
+	_, _ = rand.Read(sc.SpanID[:])
+	span.SpanContext = sc
+	return span
+}
+
+// Dropped returns the number of spans dropped because the queue was full.
+func (t *Tracer) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		t.dropped.Add(1)
+		return
+	}
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.conf.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, t.conf.BatchSize)
+	export := func() error {
+		var err error
+		for len(batch) > 0 || len(t.queue) > 0 {
+			for len(batch) < t.conf.BatchSize && len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), t.conf.FlushInterval)
+			if exportErr := t.conf.Exporter.ExportSpans(ctx, batch); exportErr != nil && err == nil {
+				err = exportErr
+			}
+			cancel()
+			batch = make([]*Span, 0, t.conf.BatchSize)
+		}
+		return err
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= t.conf.BatchSize {
+				if err := export(); err != nil {
+					debugPrint("[WARNING] Cannot export spans: %v", err)
+				}
+			}
+		case <-ticker.C:
+			if err := export(); err != nil {
+				debugPrint("[WARNING] Cannot export spans: %v", err)
+			}
+		case done := <-t.flushes:
+			done <- export()
+		case <-t.stop:
+			if err := export(); err != nil {
+				debugPrint("[WARNING] Cannot export spans: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// Flush exports the ended spans which are waiting for export.
+func (t *Tracer) Flush(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case t.flushes <- done:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the pending spans, stops the tracer and shuts the exporter down.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.conf.Exporter.Shutdown(ctx)
+}
+
+// TracingConfig defines the config for Tracing middleware.
+type TracingConfig struct {
+	// Tracer starts the server spans.
+	// Required.
+	Tracer *Tracer
+
+	// Skip is a Skipper that indicates which requests should not be traced.
+	// Optional.
+	Skip Skipper
+}
+
+// Tracing returns a middleware starting a server span for each request, with tracer.
+// See TracingWithConfig for more details.
+func Tracing(tracer *Tracer) HandlerFunc {
+	return TracingWithConfig(TracingConfig{Tracer: tracer})
+}
+
+// TracingWithConfig returns a Tracing middleware with config.
+// The span continues the trace of the traceparent and tracestate request headers (W3C Trace
+// Context), if any, or starts a new trace. It is named after the method and the route pattern,
+// such as "GET /users/:id", and records the status code and the errors of the Context. The
+// span is stored in the Context, see Context.Span, and in the request context, so that
+// Tracer.Start and InjectTraceContext continue the trace. The trace_id is added to the logger
+// of the request.
+func TracingWithConfig(conf TracingConfig) HandlerFunc {
+	assert1(conf.Tracer != nil, "a tracer is required")
+	tracer := conf.Tracer
+
+	return func(c *Context) {
+		if conf.Skip != nil && conf.Skip(c) {
+			c.Next()
+			return
+		}
+		req := c.Request
+		parent, err := ParseTraceparent(req.Header.Get("traceparent"))
+		if err == nil {
+			if ts := strings.Join(req.Header.Values("tracestate"), ","); len(ts) <= maxTraceStateLength {
+				parent.TraceState = ts
+			}
+		}
+
+		name := req.Method
+		if route := c.FullPath(); route != "" {
+			name += " " + route
+		}
+		span := tracer.start(parent, name, SpanKindServer)
+		span.SetAttributes(
+			SpanAttribute{Key: "http.request.method", Value: req.Method},
+			SpanAttribute{Key: "url.path", Value: req.URL.Path},
+			SpanAttribute{Key: "client.address", Value: c.ClientIP()},
+		)
+		if route := c.FullPath(); route != "" {
+			span.SetAttributes(SpanAttribute{Key: "http.route", Value: route})
+		}
+		if ua := req.UserAgent(); ua != "" {
+			span.SetAttributes(SpanAttribute{Key: "user_agent.original", Value: ua})
+		}
+		c.Request = req.WithContext(ContextWithSpan(req.Context(), span))
+		c.Set(SpanKey, span)
+		c.AddLogAttrs("trace_id", span.SpanContext.TraceID.String())
+		defer span.End()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(SpanAttribute{Key: "http.response.status_code", Value: status})
+		for _, err := range c.Errors {
+			span.RecordError(err.Err)
+		}
+		if status >= http.StatusInternalServerError {
+			message := http.StatusText(status)
+			if last := c.Errors.Last(); last != nil {
+				message = last.Error()
+			}
+			span.SetStatus(SpanStatusError, message)
+		}
+	}
+}
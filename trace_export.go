@@ -0,0 +1,252 @@
+package vira
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	json "github.com/vira-software/vira/internal"
+)
+
+const maxOTLPErrorSize = 4 << 10
+
+// InMemoryExporter is a SpanExporter keeping the spans in memory, for tests and debugging.
+// An InMemoryExporter is safe for concurrent use.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+var _ SpanExporter = (*InMemoryExporter)(nil)
+
+// NewInMemoryExporter returns an empty InMemoryExporter.
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// ExportSpans implements SpanExporter.
+func (e *InMemoryExporter) ExportSpans(_ context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Shutdown implements SpanExporter.
+func (e *InMemoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// Spans returns the exported spans, in export order.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset removes the exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// OTLPExporter is a SpanExporter sending the spans to an OpenTelemetry collector with the
+// OTLP/HTTP protocol, JSON encoded.
+type OTLPExporter struct {
+	// Endpoint is the URL of the traces endpoint of the collector, such as
+	// "http://localhost:4318/v1/traces".
+	// Required.
+	Endpoint string
+
+	// Headers are added to the export requests, for example to authenticate them.
+	// Optional.
+	Headers map[string]string
+
+	// HTTPClient sends the export requests.
+	// Optional. Default value is http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+var _ SpanExporter = (*OTLPExporter)(nil)
+
+// NewOTLPExporter returns an OTLPExporter sending the spans to endpoint.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{Endpoint: endpoint}
+}
+
+// ExportSpans implements SpanExporter.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(newOTLPTraces(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := e.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxOTLPErrorSize))
+		return fmt.Errorf("vira: OTLP export: unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Shutdown implements SpanExporter.
+func (e *OTLPExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// The OTLP JSON encoding: IDs are hex encoded, and 64-bit integers are strings.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		TraceState        string         `json:"traceState,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpStatus struct {
+		Code    SpanStatus `json:"code,omitempty"`
+		Message string     `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    string   `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// newOTLPTraces groups spans by service name, the resource of the spans.
+func newOTLPTraces(spans []*Span) *otlpTraces {
+	traces := &otlpTraces{}
+	index := make(map[string]int)
+	for _, span := range spans {
+		i, ok := index[span.ServiceName]
+		if !ok {
+			i = len(traces.ResourceSpans)
+			index[span.ServiceName] = i
+			traces.ResourceSpans = append(traces.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{Attributes: otlpAttributes([]SpanAttribute{
+					{Key: "service.name", Value: span.ServiceName},
+				})},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/vira-software/vira"}}},
+			})
+		}
+		scope := &traces.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, newOTLPSpan(span))
+	}
+	return traces
+}
+
+func newOTLPSpan(span *Span) otlpSpan {
+	s := otlpSpan{
+		TraceID:           span.SpanContext.TraceID.String(),
+		SpanID:            span.SpanContext.SpanID.String(),
+		TraceState:        span.SpanContext.TraceState,
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		Attributes:        otlpAttributes(span.Attributes),
+		Status:            otlpStatus{Code: span.Status, Message: span.StatusMessage},
+	}
+	if span.Parent.IsValid() {
+		s.ParentSpanID = span.Parent.SpanID.String()
+	}
+	for _, event := range span.Events {
+		s.Events = append(s.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+			Name:         event.Name,
+			Attributes:   otlpAttributes(event.Attributes),
+		})
+	}
+	return s
+}
+
+func otlpAttributes(attrs []SpanAttribute) []otlpKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		var value otlpAnyValue
+		switch v := attr.Value.(type) {
+		case string:
+			value.StringValue = &v
+		case bool:
+			value.BoolValue = &v
+		case int:
+			value.IntValue = strconv.FormatInt(int64(v), 10)
+		case int64:
+			value.IntValue = strconv.FormatInt(v, 10)
+		case uint64:
+			value.IntValue = strconv.FormatUint(v, 10)
+		case float64:
+			value.DoubleValue = &v
+		case float32:
+			f := float64(v)
+			value.DoubleValue = &f
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: attr.Key, Value: value})
+	}
+	return kvs
+}
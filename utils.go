@@ -158,3 +158,26 @@ func isASCII(s string) bool {
 	}
 	return true
 }
+
+// addVary adds the given header names to the Vary header, unless they are already listed.
+func addVary(header http.Header, names ...string) {
+	existing := header.Values("Vary")
+	for _, name := range names {
+		found := false
+		for _, line := range existing {
+			for _, v := range strings.Split(line, ",") {
+				if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, name) {
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			header.Add("Vary", name)
+			existing = header.Values("Vary")
+		}
+	}
+}
@@ -3,6 +3,7 @@ package vira
 import (
 	"fmt"
 	"html/template"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"sync"
 
 	bytesconv "github.com/vira-software/vira/internal"
+	"github.com/vira-software/vira/render"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 )
@@ -62,6 +64,8 @@ type RouteInfo struct {
 	Path        string
 	Handler     string
 	HandlerFunc HandlerFunc
+	// Policies are the names of the policies guarding the route, see RouterGroup.Authorize.
+	Policies []string
 }
 
 // RoutesInfo defines a RouteInfo slice.
@@ -154,6 +158,20 @@ type Vira struct {
 	// ContextWithFallback enable fallback Context.Deadline(), Context.Done(), Context.Err() and Context.Value() when Context.Request.Context() is not nil.
 	ContextWithFallback bool
 
+	// Keyring holds the secrets used by Context.SetSignedCookie and Context.SetEncryptedCookie.
+	// It supports key rotation, see NewKeyring.
+	Keyring *Keyring
+
+	// Logger is the logger Context.Logger derives the request-scoped loggers from. When it is
+	// set, the Logger and Recovery middleware without explicit output log through them.
+	// Defaults to slog.Default() for Context.Logger.
+	Logger *slog.Logger
+
+	// ProblemDetails if enabled, the built-in error responses are Problem Details (RFC 9457):
+	// the 404 and 405 of the router, the binding failures of the MustBind methods and the 500
+	// of Recovery. See Context.Problem.
+	ProblemDetails bool
+
 	secureJSONPrefix string
 	FuncMap          template.FuncMap
 	allNoRoute       HandlersChain
@@ -166,6 +184,7 @@ type Vira struct {
 	maxSections      uint16
 	trustedProxies   []string
 	trustedCIDRs     []*net.IPNet
+	routePolicies    map[string][]string
 }
 
 var _ IRouter = (*Vira)(nil)
@@ -312,9 +331,22 @@ func (engine *Vira) Routes() (routes RoutesInfo) {
 	for _, tree := range engine.trees {
 		routes = iterate("", tree.method, routes, tree.root)
 	}
+	for i := range routes {
+		routes[i].Policies = engine.routePolicies[routes[i].Method+" "+routes[i].Path]
+	}
 	return routes
 }
 
+func (engine *Vira) setRoutePolicies(method, path string, policies []string) {
+	if len(policies) == 0 {
+		return
+	}
+	if engine.routePolicies == nil {
+		engine.routePolicies = make(map[string][]string)
+	}
+	engine.routePolicies[method+" "+path] = policies
+}
+
 func iterate(path, method string, routes RoutesInfo, root *node) RoutesInfo {
 	path += root.path
 	if len(root.handlers) > 0 {
@@ -628,6 +660,10 @@ func serveError(c *Context, code int, defaultMessage []byte) {
 		return
 	}
 	if c.writermem.Status() == code {
+		if c.engine.ProblemDetails {
+			c.Problem(code, render.Problem{})
+			return
+		}
 		c.writermem.Header()["Content-Type"] = mimePlain
 		_, err := c.Writer.Write(defaultMessage)
 		if err != nil {